@@ -3,8 +3,15 @@ package internals
 import (
 	"errors"
 	"fmt"
+	"maps"
+	"math"
 	"os"
+	"regexp"
 	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
@@ -17,14 +24,405 @@ type SplitbitConfig struct {
 	Algorithm string          `yaml:"algorithm"`
 	Scheme    string          `yaml:"scheme"`
 	Backends  []BackendConfig `yaml:"backends"`
+
+	// AlgorithmOptions are passed as is to the selector of the configured algorithm
+	AlgorithmOptions map[string]string `yaml:"algorithm_options"`
+
+	// Zone is the zone or region this splitbit instance runs in, backends in the same zone are preferred
+	Zone string `yaml:"zone"`
+
+	// ZoneSpilloverThreshold is the fraction of the local zone capacity which must be healthy for every
+	// connection to stay in the zone, below it a proportional share of connections spills over
+	ZoneSpilloverThreshold *float64 `yaml:"zone_spillover_threshold"`
+
+	// MinHealthy is the number of alive backends a priority tier needs before traffic spills over to the next tier
+	MinHealthy int `yaml:"min_healthy"`
+
+	// HealthCheckConcurrency is the number of health and agent checks which may run at once
+	HealthCheckConcurrency int `yaml:"health_check_concurrency"`
+
+	// HealthCheckJitter is the fraction of their interval checks are moved by at random, so backends
+	// aren't all checked at the same instant. It is 0.1 when omitted and an explicit 0 disables it
+	HealthCheckJitter *float64 `yaml:"health_check_jitter"`
+
+	// StickTable pins clients to the backend they were first sent to, it is disabled when omitted
+	StickTable *StickTableConfig `yaml:"stick_table"`
+
+	// Sniff enables reading the first bytes of every connection to extract the TLS SNI and ALPN or the
+	// HTTP Host header before selecting a backend, this delays protocols where the server speaks first
+	Sniff bool `yaml:"sniff"`
 }
 
 type BackendConfig struct {
-	Name        string `yaml:"name"`
-	Host        string `yaml:"host"`
-	Port        int    `yaml:"port"`
-	Weight      int    `yaml:"weight"`
-	HealthCheck string `yaml:"health_check"`
+	Name        string            `yaml:"name"`
+	Host        string            `yaml:"host"`
+	Port        int               `yaml:"port"`
+	Weight      int               `yaml:"weight"`
+	HealthCheck HealthCheckConfig `yaml:"health_check"`
+
+	// Priority assigns the backend to a tier, backends with a higher priority are backups
+	// for the ones with a lower priority
+	Priority int `yaml:"priority"`
+
+	// Zone is the zone or region the backend runs in
+	Zone string `yaml:"zone"`
+
+	// PassiveHealthCheck ejects the backend based on failures of proxied connections, it is disabled when omitted
+	PassiveHealthCheck *PassiveHealthCheckConfig `yaml:"passive_health_check"`
+
+	// AgentCheck lets an agent running alongside the backend report its weight and state, it is disabled
+	// when omitted
+	AgentCheck *AgentCheckConfig `yaml:"agent_check"`
+
+	// FlapDamping holds the backend back when it keeps going down, it is disabled when omitted
+	FlapDamping *FlapDampingConfig `yaml:"flap_damping"`
+
+	// SlowStart is how long the weight of the backend ramps up for after it becomes healthy, such as "30s"
+	SlowStart time.Duration `yaml:"slow_start"`
+}
+
+// AlgorithmValidator checks that the options provided for an algorithm are valid
+type AlgorithmValidator func(options map[string]string) error
+
+var (
+	// algorithms holds every algorithm that can be used in the configuration, they are registered
+	// by the selector registry so that validation never drifts from the selectors that can be built
+	algorithms   = map[string]AlgorithmValidator{}
+	algorithmsMu sync.RWMutex
+)
+
+var (
+	// hashKeys holds every key clients can be identified by, they are registered by the services package
+	// like algorithms are
+	hashKeys   = map[string]bool{}
+	hashKeysMu sync.RWMutex
+)
+
+// RegisterHashKey makes a hash key valid in the configuration
+func RegisterHashKey(name string) {
+	hashKeysMu.Lock()
+	defer hashKeysMu.Unlock()
+
+	hashKeys[name] = true
+}
+
+// HashKeys returns the sorted names of every registered hash key
+func HashKeys() []string {
+	hashKeysMu.RLock()
+	defer hashKeysMu.RUnlock()
+
+	names := make([]string, 0, len(hashKeys))
+	for name := range hashKeys {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+	return names
+}
+
+// RegisterAlgorithm makes an algorithm valid in the configuration, validate may be nil if the
+// algorithm doesn't accept any options
+func RegisterAlgorithm(name string, validate AlgorithmValidator) {
+	algorithmsMu.Lock()
+	defer algorithmsMu.Unlock()
+
+	algorithms[name] = validate
+}
+
+// Algorithms returns the sorted names of every registered algorithm
+func Algorithms() []string {
+	algorithmsMu.RLock()
+	defer algorithmsMu.RUnlock()
+
+	names := make([]string, 0, len(algorithms))
+	for name := range algorithms {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+	return names
+}
+
+// HealthCheckConfig describes how a backend is probed, it may also be provided as a plain path
+// (health_check: "/health") which is shorthand for an HTTP check of that path
+type HealthCheckConfig struct {
+	// Type is one of tcp, http, grpc, redis, postgres, mysql or exec, defaulting to http when a path is provided and tcp otherwise
+	Type string `yaml:"type"`
+
+	// Path is the path requested by http checks
+	Path string `yaml:"path"`
+
+	// Port overrides the port of the backend for checks, for services exposing health on an admin port
+	Port int `yaml:"port"`
+
+	// Scheme is either http or https for http and grpc checks, defaulting to https when tls is configured
+	Scheme string `yaml:"scheme"`
+
+	// GRPCService is the service name sent by grpc checks, the overall health of the server is checked
+	// when it is empty
+	GRPCService string `yaml:"grpc_service"`
+
+	// TLS configures the connection of checks using the https scheme
+	TLS HealthCheckTLSConfig `yaml:"tls"`
+
+	// Method is the HTTP method of http checks, defaulting to GET
+	Method string `yaml:"method"`
+
+	// Host overrides the Host header of http checks
+	Host string `yaml:"host"`
+
+	// Headers are added to the requests of http checks
+	Headers map[string]string `yaml:"headers"`
+
+	// ExpectStatus lists the status codes http checks accept, such as 204, "200-299" or "2xx". Only a 200
+	// is accepted when it is empty
+	ExpectStatus []StatusRange `yaml:"expect_status"`
+
+	// ExpectBody is a substring the response body of http checks must contain
+	ExpectBody string `yaml:"expect_body"`
+
+	// ExpectBodyRegex is a regular expression the response body of http checks must match
+	ExpectBodyRegex string `yaml:"expect_body_regex"`
+
+	// MaxResponseSize is the number of bytes of the response body http checks read at most, larger
+	// responses fail the check
+	MaxResponseSize int64 `yaml:"max_response_size"`
+
+	// Command is the command and its arguments run by exec checks
+	Command []string `yaml:"command"`
+
+	// Username and Password authenticate redis, postgres and mysql checks, redis only needs a password
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+
+	// Database is the database postgres and mysql checks connect to
+	Database string `yaml:"database"`
+
+	// Query is the simple query run by postgres checks, defaulting to SELECT 1
+	Query string `yaml:"query"`
+
+	// Role is the replication role redis checks require, either master or replica. Any role passes when
+	// it is empty
+	Role string `yaml:"role"`
+
+	// Interval is the time between two checks
+	Interval time.Duration `yaml:"interval"`
+
+	// Timeout is how long a check may take before it is considered failed
+	Timeout time.Duration `yaml:"timeout"`
+
+	// Rise is the number of consecutive successful checks needed to bring a backend back up
+	Rise int `yaml:"rise"`
+
+	// Fall is the number of consecutive failed checks needed to take a backend down
+	Fall int `yaml:"fall"`
+
+	// Cooldown is how long a backend stays down before it is checked again, it doubles every time the
+	// backend fails to recover
+	Cooldown time.Duration `yaml:"cooldown"`
+
+	// MaxCooldown caps the cooldown of a backend which keeps failing to recover
+	MaxCooldown time.Duration `yaml:"max_cooldown"`
+}
+
+// HealthCheckTLSConfig configures how checks connect to backends over TLS
+type HealthCheckTLSConfig struct {
+	// ServerName overrides the name sent through SNI and verified against the certificate of the backend
+	ServerName string `yaml:"server_name"`
+
+	// CAFile is a PEM bundle of the authorities trusted to sign the certificate of the backend, the system
+	// pool is used when it is empty
+	CAFile string `yaml:"ca_file"`
+
+	// CertFile and KeyFile are the PEM client certificate and key presented to backends requiring mTLS
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+
+	// InsecureSkipVerify disables verifying the certificate of the backend
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+}
+
+// Enabled reports whether any TLS option is set
+func (cfg *HealthCheckTLSConfig) Enabled() bool {
+	return *cfg != HealthCheckTLSConfig{}
+}
+
+func (cfg *HealthCheckTLSConfig) Validate() error {
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return errors.New("cert_file and key_file must be provided together")
+	}
+
+	return nil
+}
+
+// StatusRange is an inclusive range of HTTP status codes
+type StatusRange struct {
+	Min int
+	Max int
+}
+
+// ParseStatusRange parses a single status code such as 204, a range such as 200-299 or a class such as 2xx
+func ParseStatusRange(value string) (StatusRange, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+
+	if class, ok := strings.CutSuffix(value, "xx"); ok {
+		digit, err := strconv.Atoi(class)
+		if err != nil || digit < 1 || digit > 5 {
+			return StatusRange{}, fmt.Errorf("invalid status class %q", value)
+		}
+
+		return StatusRange{Min: digit * 100, Max: digit*100 + 99}, nil
+	}
+
+	low, high, isRange := strings.Cut(value, "-")
+	if !isRange {
+		high = low
+	}
+
+	minimum, minErr := strconv.Atoi(strings.TrimSpace(low))
+	maximum, maxErr := strconv.Atoi(strings.TrimSpace(high))
+	if minErr != nil || maxErr != nil || minimum < 100 || maximum > 599 || minimum > maximum {
+		return StatusRange{}, fmt.Errorf("invalid status range %q", value)
+	}
+
+	return StatusRange{Min: minimum, Max: maximum}, nil
+}
+
+// Contains reports whether the status code falls within the range
+func (r StatusRange) Contains(status int) bool {
+	return status >= r.Min && status <= r.Max
+}
+
+// UnmarshalYAML parses the status range from its string form
+func (r *StatusRange) UnmarshalYAML(unmarshal func(any) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+
+	parsed, err := ParseStatusRange(value)
+	if err != nil {
+		return err
+	}
+
+	*r = parsed
+	return nil
+}
+
+// UnmarshalYAML accepts either a health check mapping or a plain path
+func (cfg *HealthCheckConfig) UnmarshalYAML(unmarshal func(any) error) error {
+	var path string
+	if err := unmarshal(&path); err == nil {
+		*cfg = HealthCheckConfig{Type: "http", Path: path}
+		return nil
+	}
+
+	// The alias drops the UnmarshalYAML method so the mapping is decoded field by field
+	type rawHealthCheckConfig HealthCheckConfig
+
+	var raw rawHealthCheckConfig
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+
+	*cfg = HealthCheckConfig(raw)
+	return nil
+}
+
+type PassiveHealthCheckConfig struct {
+	// ConsecutiveFailures is the number of dial failures in a row which eject the backend
+	ConsecutiveFailures int `yaml:"consecutive_failures"`
+
+	// ErrorRate is the fraction of failed connections within the window which ejects the backend
+	ErrorRate float64 `yaml:"error_rate"`
+
+	// Window is how far back connections are considered for the error rate
+	Window time.Duration `yaml:"window"`
+
+	// MinRequests is the number of connections needed within the window before the error rate is trusted
+	MinRequests int `yaml:"min_requests"`
+}
+
+const (
+	// DefaultPassiveConsecutiveFailures is the number of dial failures in a row which eject a backend
+	DefaultPassiveConsecutiveFailures = 5
+
+	// DefaultPassiveErrorRate is the fraction of failed connections which ejects a backend
+	DefaultPassiveErrorRate = 0.5
+
+	// DefaultPassiveWindow is how far back connections are considered for the error rate
+	DefaultPassiveWindow = 30 * time.Second
+
+	// MinPassiveWindow keeps the buckets of the window from being too narrow to tell apart
+	MinPassiveWindow = time.Second
+
+	// DefaultPassiveMinRequests is the number of connections the window must hold to trust the error rate
+	DefaultPassiveMinRequests = 10
+)
+
+// AgentCheckConfig configures the agent check of a backend, the agent replies with a line such as
+// "up 75%", "drain", "maint" or "down"
+type AgentCheckConfig struct {
+	// Port is the side port the agent listens on
+	Port int `yaml:"port"`
+
+	// Send is written to the agent before its reply is read
+	Send string `yaml:"send"`
+
+	// Interval is the time between two agent checks
+	Interval time.Duration `yaml:"interval"`
+
+	// Timeout is how long the agent has to reply
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// FlapDampingConfig configures flap damping of a backend, every time it goes down its flap score grows by one
+// and the score halves every half life
+type FlapDampingConfig struct {
+	// HalfLife is how long it takes for the flap score to decay by half
+	HalfLife time.Duration `yaml:"half_life"`
+
+	// SuppressThreshold is the flap score at which the backend is suppressed
+	SuppressThreshold float64 `yaml:"suppress_threshold"`
+
+	// ReuseThreshold is the flap score below which a suppressed backend may recover
+	ReuseThreshold float64 `yaml:"reuse_threshold"`
+
+	// MaxSuppressTime caps how long the backend stays suppressed
+	MaxSuppressTime time.Duration `yaml:"max_suppress_time"`
+}
+
+const (
+	// DefaultFlapHalfLife is how long it takes for the flap score to decay by half
+	DefaultFlapHalfLife = time.Minute
+
+	// DefaultFlapSuppressThreshold is the flap score at which a backend is suppressed
+	DefaultFlapSuppressThreshold = 3
+
+	// DefaultFlapReuseThreshold is the flap score below which a suppressed backend may recover, it is
+	// lowered to half the suppress threshold when that is smaller
+	DefaultFlapReuseThreshold = 1
+
+	// DefaultFlapMaxSuppressTime caps how long a backend stays suppressed however much it flapped
+	DefaultFlapMaxSuppressTime = 10 * time.Minute
+)
+
+// MinFlapSuppressTime returns the shortest max suppress time which lets the flap score reach the suppress
+// threshold. The score is capped so it decays below the reuse threshold within the max suppress time, a cap
+// below the suppress threshold would never suppress the backend
+func MinFlapSuppressTime(halfLife time.Duration, suppressThreshold float64, reuseThreshold float64) time.Duration {
+	return time.Duration(math.Ceil(float64(halfLife) * math.Log2(suppressThreshold/reuseThreshold)))
+}
+
+type StickTableConfig struct {
+	// Key is what clients are identified by, such as "source-ip" or a sniffed value like "sni"
+	Key string `yaml:"key"`
+
+	// TTL is how long an entry is kept without being used
+	TTL time.Duration `yaml:"ttl"`
+
+	// MaxSize is the number of entries kept before the least recently used ones are evicted
+	MaxSize int `yaml:"max_size"`
 }
 
 func (cfg *SplitbitConfig) Validate() error {
@@ -55,9 +453,50 @@ func (cfg *SplitbitConfig) Validate() error {
 attacks such as slow-loris\n`, cfg.Timeout)
 	}
 
-	supported := []string{"round-robin", "weighted-round-robin"}
-	if !slices.Contains(supported, cfg.Algorithm) {
-		return errors.New("only [round-robin, weighted-round-robin] are supported as algorithm")
+	algorithmsMu.RLock()
+	validate, ok := algorithms[cfg.Algorithm]
+	algorithmsMu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("only [%s] are supported as algorithm", strings.Join(Algorithms(), ", "))
+	}
+
+	if validate != nil {
+		if err := validate(cfg.AlgorithmOptions); err != nil {
+			return fmt.Errorf("algorithm %s: %w", cfg.Algorithm, err)
+		}
+	}
+
+	if cfg.MinHealthy == 0 {
+		cfg.MinHealthy = 1
+	} else if cfg.MinHealthy < 0 {
+		return errors.New("min_healthy must be a positive integer")
+	}
+
+	if cfg.HealthCheckConcurrency == 0 {
+		cfg.HealthCheckConcurrency = 16
+	} else if cfg.HealthCheckConcurrency < 0 {
+		return errors.New("health_check_concurrency must be a positive integer")
+	}
+
+	if cfg.HealthCheckJitter == nil {
+		jitter := 0.1
+		cfg.HealthCheckJitter = &jitter
+	} else if *cfg.HealthCheckJitter < 0 || *cfg.HealthCheckJitter > 1 {
+		return errors.New("health_check_jitter must be between 0 and 1")
+	}
+
+	if cfg.ZoneSpilloverThreshold == nil {
+		threshold := 0.7
+		cfg.ZoneSpilloverThreshold = &threshold
+	} else if *cfg.ZoneSpilloverThreshold <= 0 || *cfg.ZoneSpilloverThreshold > 1 {
+		return errors.New("zone_spillover_threshold must be greater than 0 and at most 1")
+	}
+
+	if cfg.StickTable != nil {
+		if err := cfg.StickTable.Validate(); err != nil {
+			return fmt.Errorf("stick_table: %w", err)
+		}
 	}
 
 	schemes := []string{"tcp"}
@@ -69,12 +508,27 @@ attacks such as slow-loris\n`, cfg.Timeout)
 		return errors.New("at least one backend is required")
 	}
 
-	for i, backend := range cfg.Backends {
+	for i := range cfg.Backends {
+		backend := &cfg.Backends[i]
 		if err := backend.Validate(); err != nil {
 			return fmt.Errorf("backend %d (%s): %w", i, backend.Name, err)
 		}
 	}
 
+	// A tier with fewer backends than min_healthy only receives traffic once every tier is below it, which is
+	// fine for a small disaster recovery tier but likely a mistake for the primaries
+	tierSizes := map[int]int{}
+	for _, backend := range cfg.Backends {
+		tierSizes[backend.Priority]++
+	}
+
+	for _, priority := range slices.Sorted(maps.Keys(tierSizes)) {
+		if size := tierSizes[priority]; size < cfg.MinHealthy {
+			fmt.Printf("min_healthy is %d but priority %d only has %d backends, it only receives traffic once every tier is below min_healthy\n",
+				cfg.MinHealthy, priority, size)
+		}
+	}
+
 	return nil
 }
 
@@ -91,8 +545,8 @@ func (cfg *BackendConfig) Validate() error {
 		return errors.New("a valid port is required for the configuration")
 	}
 
-	if cfg.HealthCheck == "" {
-		return errors.New("health_check is required for the configuration")
+	if err := cfg.HealthCheck.Validate(); err != nil {
+		return fmt.Errorf("health_check: %w", err)
 	}
 
 	if cfg.Weight < 0 {
@@ -104,6 +558,280 @@ func (cfg *BackendConfig) Validate() error {
 		cfg.Weight = 1
 	}
 
+	if cfg.PassiveHealthCheck != nil {
+		if err := cfg.PassiveHealthCheck.Validate(); err != nil {
+			return fmt.Errorf("passive_health_check: %w", err)
+		}
+	}
+
+	if cfg.AgentCheck != nil {
+		if err := cfg.AgentCheck.Validate(); err != nil {
+			return fmt.Errorf("agent_check: %w", err)
+		}
+	}
+
+	if cfg.FlapDamping != nil {
+		if err := cfg.FlapDamping.Validate(); err != nil {
+			return fmt.Errorf("flap_damping: %w", err)
+		}
+	}
+
+	if cfg.SlowStart < 0 {
+		return fmt.Errorf("slow_start must not be negative, found %s for %s", cfg.SlowStart, cfg.Name)
+	}
+
+	if cfg.Priority < 0 {
+		return fmt.Errorf("priority must not be negative, found %d for %s", cfg.Priority, cfg.Name)
+	}
+
+	return nil
+}
+
+func (cfg *HealthCheckConfig) Validate() error {
+	if cfg.Type == "" {
+		cfg.Type = "tcp"
+		if cfg.Path != "" {
+			cfg.Type = "http"
+		}
+	}
+
+	switch cfg.Type {
+	case "tcp":
+	case "http":
+		if cfg.Path == "" {
+			cfg.Path = "/health"
+		}
+
+		if err := cfg.validateScheme(); err != nil {
+			return err
+		}
+
+		if cfg.Method == "" {
+			cfg.Method = "GET"
+		}
+		cfg.Method = strings.ToUpper(cfg.Method)
+
+		if len(cfg.ExpectStatus) == 0 {
+			cfg.ExpectStatus = []StatusRange{{Min: 200, Max: 200}}
+		}
+
+		if cfg.ExpectBodyRegex != "" {
+			if _, err := regexp.Compile(cfg.ExpectBodyRegex); err != nil {
+				return fmt.Errorf("expect_body_regex: %w", err)
+			}
+		}
+
+		if cfg.MaxResponseSize == 0 {
+			cfg.MaxResponseSize = 64 * 1024
+		} else if cfg.MaxResponseSize < 0 {
+			return errors.New("max_response_size must be positive")
+		}
+	case "grpc":
+		if err := cfg.validateScheme(); err != nil {
+			return err
+		}
+	case "redis":
+		switch cfg.Role {
+		case "", "master", "replica":
+		case "slave":
+			cfg.Role = "replica"
+		default:
+			return errors.New("only [master, replica] are supported as redis role")
+		}
+	case "postgres":
+		if cfg.Username == "" {
+			return errors.New("username is required for postgres health checks")
+		}
+
+		if cfg.Query == "" {
+			cfg.Query = "SELECT 1"
+		}
+	case "mysql":
+		if cfg.Username == "" {
+			return errors.New("username is required for mysql health checks")
+		}
+	case "exec":
+		if len(cfg.Command) == 0 {
+			return errors.New("command is required for exec health checks")
+		}
+	default:
+		return errors.New("only [tcp, http, grpc, redis, postgres, mysql, exec] are supported as health check type")
+	}
+
+	if cfg.TLS.Enabled() {
+		if cfg.Type != "http" && cfg.Type != "grpc" {
+			return fmt.Errorf("tls is only supported by [http, grpc] health checks, not %s", cfg.Type)
+		}
+
+		if cfg.Scheme != "https" {
+			return errors.New("tls requires the https scheme")
+		}
+	}
+
+	if err := cfg.TLS.Validate(); err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+
+	if cfg.Port < 0 || cfg.Port > 65535 {
+		return errors.New("port must be between 0 and 65535")
+	}
+
+	if cfg.Interval == 0 {
+		cfg.Interval = 5 * time.Second
+	} else if cfg.Interval < 0 {
+		return errors.New("interval must be positive")
+	}
+
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 3 * time.Second
+	} else if cfg.Timeout < 0 {
+		return errors.New("timeout must be positive")
+	}
+
+	if cfg.Rise == 0 {
+		cfg.Rise = 2
+	} else if cfg.Rise < 0 {
+		return errors.New("rise must be a positive integer")
+	}
+
+	if cfg.Fall == 0 {
+		cfg.Fall = 3
+	} else if cfg.Fall < 0 {
+		return errors.New("fall must be a positive integer")
+	}
+
+	if cfg.Cooldown == 0 {
+		cfg.Cooldown = 10 * time.Second
+	} else if cfg.Cooldown < 0 {
+		return errors.New("cooldown must be positive")
+	}
+
+	if cfg.MaxCooldown == 0 {
+		cfg.MaxCooldown = max(5*time.Minute, cfg.Cooldown)
+	} else if cfg.MaxCooldown < cfg.Cooldown {
+		return errors.New("max_cooldown must not be lower than cooldown")
+	}
+
+	return nil
+}
+
+// validateScheme defaults the scheme of http and grpc checks, which use TLS when it is configured
+func (cfg *HealthCheckConfig) validateScheme() error {
+	if cfg.Scheme == "" {
+		cfg.Scheme = "http"
+		if cfg.TLS.Enabled() {
+			cfg.Scheme = "https"
+		}
+	}
+
+	if cfg.Scheme != "http" && cfg.Scheme != "https" {
+		return errors.New("only [http, https] are supported as health check scheme")
+	}
+
+	return nil
+}
+
+func (cfg *PassiveHealthCheckConfig) Validate() error {
+	if cfg.ConsecutiveFailures == 0 {
+		cfg.ConsecutiveFailures = DefaultPassiveConsecutiveFailures
+	} else if cfg.ConsecutiveFailures < 0 {
+		return errors.New("consecutive_failures must be a positive integer")
+	}
+
+	if cfg.ErrorRate == 0 {
+		cfg.ErrorRate = DefaultPassiveErrorRate
+	} else if cfg.ErrorRate < 0 || cfg.ErrorRate > 1 {
+		return errors.New("error_rate must be between 0 and 1")
+	}
+
+	if cfg.Window == 0 {
+		cfg.Window = DefaultPassiveWindow
+	} else if cfg.Window < MinPassiveWindow {
+		return fmt.Errorf("window must be at least %s", MinPassiveWindow)
+	}
+
+	if cfg.MinRequests == 0 {
+		cfg.MinRequests = DefaultPassiveMinRequests
+	} else if cfg.MinRequests < 0 {
+		return errors.New("min_requests must be a positive integer")
+	}
+
+	return nil
+}
+
+func (cfg *AgentCheckConfig) Validate() error {
+	if cfg.Port <= 0 || cfg.Port > 65535 {
+		return errors.New("port must be between 1 and 65535")
+	}
+
+	if cfg.Interval == 0 {
+		cfg.Interval = 5 * time.Second
+	} else if cfg.Interval < 0 {
+		return errors.New("interval must be positive")
+	}
+
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 2 * time.Second
+	} else if cfg.Timeout < 0 {
+		return errors.New("timeout must be positive")
+	}
+
+	return nil
+}
+
+func (cfg *FlapDampingConfig) Validate() error {
+	if cfg.HalfLife == 0 {
+		cfg.HalfLife = DefaultFlapHalfLife
+	} else if cfg.HalfLife < 0 {
+		return errors.New("half_life must be positive")
+	}
+
+	if cfg.SuppressThreshold == 0 {
+		cfg.SuppressThreshold = DefaultFlapSuppressThreshold
+	} else if cfg.SuppressThreshold < 0 {
+		return errors.New("suppress_threshold must be positive")
+	}
+
+	if cfg.ReuseThreshold == 0 {
+		cfg.ReuseThreshold = min(DefaultFlapReuseThreshold, cfg.SuppressThreshold/2)
+	} else if cfg.ReuseThreshold < 0 || cfg.ReuseThreshold >= cfg.SuppressThreshold {
+		return errors.New("reuse_threshold must be positive and lower than suppress_threshold")
+	}
+
+	if cfg.MaxSuppressTime == 0 {
+		cfg.MaxSuppressTime = DefaultFlapMaxSuppressTime
+	} else if cfg.MaxSuppressTime < 0 {
+		return errors.New("max_suppress_time must be positive")
+	}
+
+	if minimum := MinFlapSuppressTime(cfg.HalfLife, cfg.SuppressThreshold, cfg.ReuseThreshold); cfg.MaxSuppressTime < minimum {
+		return fmt.Errorf("max_suppress_time must be at least %s for the score to reach suppress_threshold", minimum)
+	}
+
+	return nil
+}
+
+func (cfg *StickTableConfig) Validate() error {
+	if cfg.Key == "" {
+		cfg.Key = "source-ip"
+	}
+
+	if !slices.Contains(HashKeys(), cfg.Key) {
+		return fmt.Errorf("only [%s] are supported as key", strings.Join(HashKeys(), ", "))
+	}
+
+	if cfg.TTL == 0 {
+		cfg.TTL = 30 * time.Minute
+	} else if cfg.TTL < 0 {
+		return errors.New("ttl must be positive")
+	}
+
+	if cfg.MaxSize == 0 {
+		cfg.MaxSize = 100_000
+	} else if cfg.MaxSize < 0 {
+		return errors.New("max_size must be a positive integer")
+	}
+
 	return nil
 }
 
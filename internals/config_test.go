@@ -1,10 +1,32 @@
 package internals
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
 )
 
+func init() {
+	// Selectors register their algorithms from the services package, which can't be imported here
+	RegisterAlgorithm("round-robin", nil)
+	RegisterAlgorithm("weighted-round-robin", func(options map[string]string) error {
+		if len(options) > 0 {
+			return errors.New("unknown option")
+		}
+
+		return nil
+	})
+
+	RegisterHashKey("source-ip")
+	RegisterHashKey("sni")
+}
+
 func TestValidateConfig(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -24,7 +46,7 @@ func TestValidateConfig(t *testing.T) {
 						Host:        "127.0.0.1",
 						Port:        8000,
 						Weight:      2,
-						HealthCheck: "/health",
+						HealthCheck: HealthCheckConfig{Path: "/health"},
 					},
 				},
 			},
@@ -43,13 +65,33 @@ func TestValidateConfig(t *testing.T) {
 						Host:        "127.0.0.1",
 						Port:        8000,
 						Weight:      2,
-						HealthCheck: "/health",
+						HealthCheck: HealthCheckConfig{Path: "/health"},
 					},
 				},
 			},
 			expectsError: true,
 			expects:      "are supported as algorithm",
 		},
+		{
+			name: "with invalid algorithm options",
+			config: SplitbitConfig{
+				Name:             "Splitbit Config",
+				Algorithm:        "weighted-round-robin",
+				AlgorithmOptions: map[string]string{"virtual_nodes": "100"},
+				Scheme:           "tcp",
+				Backends: []BackendConfig{
+					{
+						Name:        "test",
+						Host:        "127.0.0.1",
+						Port:        8000,
+						Weight:      2,
+						HealthCheck: HealthCheckConfig{Path: "/health"},
+					},
+				},
+			},
+			expectsError: true,
+			expects:      "algorithm weighted-round-robin: unknown option",
+		},
 		{
 			name: "without backends provided",
 			config: SplitbitConfig{
@@ -73,7 +115,7 @@ func TestValidateConfig(t *testing.T) {
 						Host:        "127.0.0.1",
 						Port:        80_000,
 						Weight:      2,
-						HealthCheck: "/health",
+						HealthCheck: HealthCheckConfig{Path: "/health"},
 					},
 				},
 			},
@@ -92,13 +134,122 @@ func TestValidateConfig(t *testing.T) {
 						Host:        "127.0.0.1",
 						Port:        8000,
 						Weight:      -1,
-						HealthCheck: "/health",
+						HealthCheck: HealthCheckConfig{Path: "/health"},
 					},
 				},
 			},
 			expectsError: true,
 			expects:      "weight must be a positive integer",
 		},
+		{
+			name: "with a negative priority",
+			config: SplitbitConfig{
+				Name:      "Splitbit Config",
+				Algorithm: "round-robin",
+				Scheme:    "tcp",
+				Backends: []BackendConfig{
+					{
+						Name:        "test",
+						Host:        "127.0.0.1",
+						Port:        8000,
+						HealthCheck: HealthCheckConfig{Path: "/health"},
+						Priority:    -1,
+					},
+				},
+			},
+			expectsError: true,
+			expects:      "priority must not be negative",
+		},
+		{
+			name: "with a misspelled stick table key",
+			config: SplitbitConfig{
+				Name:       "Splitbit Config",
+				Algorithm:  "round-robin",
+				Scheme:     "tcp",
+				StickTable: &StickTableConfig{Key: "sorce-ip"},
+				Backends: []BackendConfig{
+					{
+						Name:        "test",
+						Host:        "127.0.0.1",
+						Port:        8000,
+						HealthCheck: HealthCheckConfig{Path: "/health"},
+					},
+				},
+			},
+			expectsError: true,
+			expects:      "stick_table: only [sni, source-ip] are supported as key",
+		},
+		{
+			name: "with a disaster recovery tier smaller than min_healthy",
+			config: SplitbitConfig{
+				Name:       "Splitbit Config",
+				Algorithm:  "round-robin",
+				Scheme:     "tcp",
+				MinHealthy: 2,
+				Backends: []BackendConfig{
+					{Name: "primary-one", Host: "127.0.0.1", Port: 8000},
+					{Name: "primary-two", Host: "127.0.0.1", Port: 8001},
+					{Name: "disaster-recovery", Host: "127.0.0.1", Port: 8002, Priority: 1},
+				},
+			},
+			expectsError: false,
+			expects:      "",
+		},
+		{
+			name: "with tls on a tcp health check",
+			config: SplitbitConfig{
+				Name:      "Splitbit Config",
+				Algorithm: "round-robin",
+				Scheme:    "tcp",
+				Backends: []BackendConfig{
+					{
+						Name:        "test",
+						Host:        "127.0.0.1",
+						Port:        8000,
+						HealthCheck: HealthCheckConfig{Type: "tcp", TLS: HealthCheckTLSConfig{ServerName: "api.example.com"}},
+					},
+				},
+			},
+			expectsError: true,
+			expects:      "tls is only supported by [http, grpc] health checks, not tcp",
+		},
+		{
+			name: "with tls on a plaintext http health check",
+			config: SplitbitConfig{
+				Name:      "Splitbit Config",
+				Algorithm: "round-robin",
+				Scheme:    "tcp",
+				Backends: []BackendConfig{
+					{
+						Name:        "test",
+						Host:        "127.0.0.1",
+						Port:        8000,
+						HealthCheck: HealthCheckConfig{Path: "/health", Scheme: "http", TLS: HealthCheckTLSConfig{InsecureSkipVerify: true}},
+					},
+				},
+			},
+			expectsError: true,
+			expects:      "tls requires the https scheme",
+		},
+		{
+			name: "with flap damping which can never suppress",
+			config: SplitbitConfig{
+				Name:      "Splitbit Config",
+				Algorithm: "round-robin",
+				Scheme:    "tcp",
+				Backends: []BackendConfig{
+					{
+						Name:        "test",
+						Host:        "127.0.0.1",
+						Port:        8000,
+						HealthCheck: HealthCheckConfig{Path: "/health"},
+						FlapDamping: &FlapDampingConfig{HalfLife: 10 * time.Minute, MaxSuppressTime: 5 * time.Minute},
+					},
+				},
+			},
+			expectsError: true,
+			expects:      "flap_damping: max_suppress_time must be at least",
+		},
 	}
 
 	for _, test := range tests {
@@ -121,3 +272,180 @@ func TestValidateConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "splitbit.yml")
+	data := `name: splitbit-test
+algorithm: round-robin
+scheme: tcp
+backends:
+  - name: backend-one
+    host: localhost
+    port: 8000
+    health_check: /health
+    slow_start: 30s
+    passive_health_check:
+      error_rate: 0.25
+    agent_check:
+      port: 9777
+    flap_damping:
+      half_life: 30s
+  - name: backend-two
+    host: localhost
+    port: 8001
+    health_check:
+      type: exec
+      command: ["/usr/local/bin/check", "--fast"]
+      interval: 10s
+      timeout: 1s
+      rise: 1
+      fall: 5
+      cooldown: 1m
+      max_cooldown: 10m
+  - name: backend-three
+    host: localhost
+    port: 8002
+  - name: backend-four
+    host: localhost
+    port: 8003
+    health_check:
+      path: /status
+      port: 9003
+      method: head
+      expect_status: [204, "300-399", 4xx]
+`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Backends[0].SlowStart != 30*time.Second {
+		t.Errorf("expected a slow start of 30s, got %s", cfg.Backends[0].SlowStart)
+	}
+
+	passive := PassiveHealthCheckConfig{ConsecutiveFailures: 5, ErrorRate: 0.25, Window: 30 * time.Second, MinRequests: 10}
+	if cfg.Backends[0].PassiveHealthCheck == nil || *cfg.Backends[0].PassiveHealthCheck != passive {
+		t.Errorf("expected passive health check %+v, got %+v", passive, cfg.Backends[0].PassiveHealthCheck)
+	}
+
+	agent := AgentCheckConfig{Port: 9777, Interval: 5 * time.Second, Timeout: 2 * time.Second}
+	if cfg.Backends[0].AgentCheck == nil || *cfg.Backends[0].AgentCheck != agent {
+		t.Errorf("expected agent check %+v, got %+v", agent, cfg.Backends[0].AgentCheck)
+	}
+
+	damping := FlapDampingConfig{HalfLife: 30 * time.Second, SuppressThreshold: 3, ReuseThreshold: 1, MaxSuppressTime: 10 * time.Minute}
+	if cfg.Backends[0].FlapDamping == nil || *cfg.Backends[0].FlapDamping != damping {
+		t.Errorf("expected flap damping %+v, got %+v", damping, cfg.Backends[0].FlapDamping)
+	}
+
+	if cfg.Backends[1].PassiveHealthCheck != nil {
+		t.Errorf("expected passive health checking to be disabled by default, got %+v", cfg.Backends[1].PassiveHealthCheck)
+	}
+
+	expected := []HealthCheckConfig{
+		{Type: "http", Scheme: "http", Path: "/health", Method: "GET", ExpectStatus: []StatusRange{{200, 200}}, MaxResponseSize: 64 * 1024, Interval: 5 * time.Second, Timeout: 3 * time.Second, Rise: 2, Fall: 3, Cooldown: 10 * time.Second, MaxCooldown: 5 * time.Minute},
+		{Type: "exec", Command: []string{"/usr/local/bin/check", "--fast"}, Interval: 10 * time.Second, Timeout: time.Second, Rise: 1, Fall: 5, Cooldown: time.Minute, MaxCooldown: 10 * time.Minute},
+		{Type: "tcp", Interval: 5 * time.Second, Timeout: 3 * time.Second, Rise: 2, Fall: 3, Cooldown: 10 * time.Second, MaxCooldown: 5 * time.Minute},
+		{Type: "http", Scheme: "http", Path: "/status", Port: 9003, Method: "HEAD", ExpectStatus: []StatusRange{{204, 204}, {300, 399}, {400, 499}}, MaxResponseSize: 64 * 1024, Interval: 5 * time.Second, Timeout: 3 * time.Second, Rise: 2, Fall: 3, Cooldown: 10 * time.Second, MaxCooldown: 5 * time.Minute},
+	}
+
+	for i, backend := range cfg.Backends {
+		if !reflect.DeepEqual(backend.HealthCheck, expected[i]) {
+			t.Errorf("expected health check %+v for %s, got %+v", expected[i], backend.Name, backend.HealthCheck)
+		}
+	}
+
+	if cfg.Port != 8080 || cfg.MinHealthy != 1 {
+		t.Errorf("expected defaults to be applied, got port %d and min_healthy %d", cfg.Port, cfg.MinHealthy)
+	}
+
+	if cfg.HealthCheckConcurrency != 16 || cfg.HealthCheckJitter == nil || *cfg.HealthCheckJitter != 0.1 {
+		t.Errorf("expected scheduler defaults to be applied, got %d and %v", cfg.HealthCheckConcurrency, cfg.HealthCheckJitter)
+	}
+
+	if cfg.ZoneSpilloverThreshold == nil || *cfg.ZoneSpilloverThreshold != 0.7 {
+		t.Errorf("expected the zone spillover threshold to default to 0.7, got %v", cfg.ZoneSpilloverThreshold)
+	}
+}
+
+func TestZoneSpilloverThresholdRejectsZero(t *testing.T) {
+	var cfg SplitbitConfig
+	document := `
+name: splitbit
+algorithm: round-robin
+scheme: tcp
+zone: eu-west-1a
+zone_spillover_threshold: 0
+backends:
+  - name: backend-one
+    host: localhost
+    port: 8000
+`
+	if err := yaml.Unmarshal([]byte(document), &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// An explicit 0 must not be mistaken for an unset threshold
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "zone_spillover_threshold") {
+		t.Errorf("expected a zone_spillover_threshold of 0 to be rejected, got %v", err)
+	}
+}
+
+func TestHealthCheckJitterCanBeDisabled(t *testing.T) {
+	var cfg SplitbitConfig
+	document := `
+name: splitbit
+algorithm: round-robin
+scheme: tcp
+health_check_jitter: 0
+backends:
+  - name: backend-one
+    host: localhost
+    port: 8000
+`
+	if err := yaml.Unmarshal([]byte(document), &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.HealthCheckJitter == nil || *cfg.HealthCheckJitter != 0 {
+		t.Errorf("expected an explicit jitter of 0 to be kept, got %v", cfg.HealthCheckJitter)
+	}
+}
+
+func TestParseStatusRange(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected StatusRange
+	}{
+		{"204", StatusRange{204, 204}},
+		{"200-299", StatusRange{200, 299}},
+		{"3xx", StatusRange{300, 399}},
+		{" 5XX ", StatusRange{500, 599}},
+	}
+
+	for _, test := range tests {
+		parsed, err := ParseStatusRange(test.value)
+		if err != nil {
+			t.Errorf("unexpected error for %q: %v", test.value, err)
+			continue
+		}
+
+		if parsed != test.expected {
+			t.Errorf("expected %+v for %q, got %+v", test.expected, test.value, parsed)
+		}
+	}
+
+	for _, value := range []string{"", "ok", "299-200", "6xx", "42", "200-700"} {
+		if _, err := ParseStatusRange(value); err == nil {
+			t.Errorf("expected an error for %q", value)
+		}
+	}
+}
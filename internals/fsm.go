@@ -78,6 +78,14 @@ func (s *StateMachine) getNextState(event EventType) (StateType, error) {
 	return Default, ErrEventRejected
 }
 
+// Locked runs fn while the machine is locked, so no event is processed until it returns
+func (s *StateMachine) Locked(fn func()) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	fn()
+}
+
 // SendEvent sends an event to the state machine
 func (s *StateMachine) SendEvent(event EventType, eventCtx EventContext) error {
 	s.mutex.Lock()
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultAgentCheckInterval is the time between two agent checks
+	defaultAgentCheckInterval = 5 * time.Second
+
+	// defaultAgentCheckTimeout is how long an agent has to reply
+	defaultAgentCheckTimeout = 2 * time.Second
+
+	// agentMaxReplySize caps how much of the reply of an agent is read
+	agentMaxReplySize = 1024
+)
+
+// AgentReport is the state an agent reported for its service, every reply only changes what it mentions
+type AgentReport struct {
+	// Down is set by down, failed and stopped and cleared by up
+	Down bool
+
+	// Drain stops new clients from being sent to the service while pinned clients may still use it
+	Drain bool
+
+	// Maint stops any client from being sent to the service
+	Maint bool
+
+	// WeightPercent is the percentage of its configured weight the service should receive
+	WeightPercent int
+
+	// WeightReported is set once the agent reported a weight, the full weight is used until then
+	WeightReported bool
+}
+
+// acceptsConnections reports whether the report lets the service receive new connections, a weight of 0%
+// drains the service
+func (r AgentReport) acceptsConnections() bool {
+	return !r.Down && !r.Drain && !r.Maint && (!r.WeightReported || r.WeightPercent > 0)
+}
+
+// parseAgentReply applies a reply such as "up 75%" or "drain" onto the previous report. Words are separated
+// by spaces or commas, and anything after a # is a description which is ignored
+func parseAgentReply(reply string, previous AgentReport) (AgentReport, error) {
+	reply, _, _ = strings.Cut(reply, "#")
+
+	report := previous
+	words := strings.FieldsFunc(strings.ToLower(reply), func(r rune) bool {
+		return r == ' ' || r == ',' || r == '\t' || r == '\r' || r == '\n'
+	})
+
+	if len(words) == 0 {
+		return previous, errors.New("empty agent reply")
+	}
+
+	for _, word := range words {
+		switch word {
+		case "up":
+			report.Down = false
+		case "down", "failed", "stopped":
+			report.Down = true
+		case "ready":
+			report.Drain, report.Maint = false, false
+		case "drain":
+			report.Drain, report.Maint = true, false
+		case "maint":
+			report.Drain, report.Maint = false, true
+		default:
+			percent, isPercent := strings.CutSuffix(word, "%")
+			if !isPercent {
+				// Words such as maxconn:100 are meant for other load balancers
+				if strings.Contains(word, ":") {
+					continue
+				}
+
+				return previous, fmt.Errorf("unrecognized agent word %q", word)
+			}
+
+			weight, err := strconv.Atoi(percent)
+			if err != nil || weight < 0 {
+				return previous, fmt.Errorf("invalid agent weight %q", word)
+			}
+
+			report.WeightPercent, report.WeightReported = weight, true
+		}
+	}
+
+	return report, nil
+}
+
+// AgentChecker connects to an agent running alongside a service on a side port, and reads a single line
+// in which the agent reports the state and weight the service should have. A reported weight scales the
+// effective weight, which algorithms ignoring weights such as round-robin and maglev don't use, while
+// down, drain and maint apply to every algorithm
+type AgentChecker struct {
+	// Port is the port the agent listens on
+	Port int
+
+	// Send is written to the agent before its reply is read, nothing is sent when it is empty
+	Send string
+
+	// Interval is the time between two agent checks
+	Interval time.Duration
+
+	// Timeout is how long the agent has to reply
+	Timeout time.Duration
+}
+
+// Query connects to the agent and returns the line it replied with
+func (c *AgentChecker) Query(ctx context.Context, svc *Service) (string, error) {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = defaultAgentCheckTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	conn, err := dialHealthCheck(ctx, svc, c.Port)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = conn.Close() }()
+
+	if c.Send != "" {
+		if _, err := io.WriteString(conn, c.Send); err != nil {
+			return "", err
+		}
+	}
+
+	// Agents may close the connection right after their reply instead of ending it with a newline
+	reply, err := bufio.NewReader(io.LimitReader(conn, agentMaxReplySize)).ReadString('\n')
+	if err != nil && (!errors.Is(err, io.EOF) || reply == "") {
+		return "", err
+	}
+
+	return strings.TrimSpace(reply), nil
+}
+
+// runAgentCheck queries the agent once and applies its reply, an agent which can't be reached leaves the
+// service as it is since the agent failing doesn't mean the service is failing
+func (s *Service) runAgentCheck(ctx context.Context) {
+	reply, err := s.AgentCheck.Query(ctx, s)
+	if err != nil {
+		s.Logger.Warn("Agent check failed for service %s: %s", s.Name, err)
+		return
+	}
+
+	s.metadataMu.RLock()
+	previous := s.Metadata.Agent
+	s.metadataMu.RUnlock()
+
+	report, err := parseAgentReply(reply, previous)
+	if err != nil {
+		s.Logger.Warn("Ignoring reply of the agent of service %s: %s", s.Name, err)
+		return
+	}
+
+	if report != previous {
+		s.Logger.Info("Agent of service %s reported %q", s.Name, reply)
+	}
+
+	s.applyAgentReport(report)
+}
+
+// applyAgentReport stores the report, notifying listeners if the service became available or unavailable
+// and taking the service DOWN if the agent just reported it as down
+func (s *Service) applyAgentReport(report AgentReport) {
+	s.metadataMu.Lock()
+	previous := s.Metadata.Agent
+	s.Metadata.Agent = report
+	s.metadataMu.Unlock()
+
+	if previous.acceptsConnections() != report.acceptsConnections() {
+		s.notifyAvailabilityChange()
+	}
+
+	if report.Down && !previous.Down && s.FSM.CurrentState == StateAlive {
+		if err := s.FSM.SendEvent(EventFailure, &CommonActionCtx{svc: s}); err != nil {
+			s.Logger.Warn("FSM rejected event %s for service %s, %v", EventFailure, s.Name, err)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestParseAgentReply(t *testing.T) {
+	tests := []struct {
+		reply    string
+		previous AgentReport
+		expected AgentReport
+	}{
+		{"up 75%", AgentReport{Down: true}, AgentReport{WeightPercent: 75, WeightReported: true}},
+		{"drain", AgentReport{Maint: true}, AgentReport{Drain: true}},
+		{"maint", AgentReport{Drain: true}, AgentReport{Maint: true}},
+		{"ready", AgentReport{Drain: true, WeightPercent: 10, WeightReported: true}, AgentReport{WeightPercent: 10, WeightReported: true}},
+		{"DOWN#cpu saturated", AgentReport{}, AgentReport{Down: true}},
+		{"maxconn:100, 0%", AgentReport{}, AgentReport{WeightReported: true}},
+	}
+
+	for _, test := range tests {
+		report, err := parseAgentReply(test.reply, test.previous)
+		if err != nil {
+			t.Errorf("unexpected error for %q: %v", test.reply, err)
+			continue
+		}
+
+		if report != test.expected {
+			t.Errorf("expected %+v for %q, got %+v", test.expected, test.reply, report)
+		}
+	}
+
+	for _, reply := range []string{"", "#only a description", "sideways", "-5%", "up lots%"} {
+		if _, err := parseAgentReply(reply, AgentReport{}); err == nil {
+			t.Errorf("expected an error for %q", reply)
+		}
+	}
+}
+
+// testAgent replies to every connection with its current reply
+type testAgent struct {
+	reply string
+	mu    sync.Mutex
+}
+
+func (a *testAgent) set(reply string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.reply = reply
+}
+
+func (a *testAgent) serve(conn net.Conn) {
+	a.mu.Lock()
+	reply := a.reply
+	a.mu.Unlock()
+
+	_, _ = io.WriteString(conn, reply+"\n")
+}
+
+func TestAgentCheckAdjustsService(t *testing.T) {
+	agent := &testAgent{}
+	agentService := newTestProtocolServer(t, agent.serve)
+
+	services := newTestServices(2)
+	service := services[0]
+	service.Weight = 4
+	service.Host = agentService.Host
+	service.AgentCheck = &AgentChecker{Port: agentService.Port}
+
+	selector, err := NewMaglevSelector(services, HashKeySourceIP, 251)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// selects reports whether any of a range of clients is sent to the service
+	selects := func() bool {
+		for i := 0; i < 100; i++ {
+			if selector.SelectService(newTestRequest(i)) == service {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	agent.set("up 50%")
+	service.runAgentCheck(context.Background())
+	if weight := service.EffectiveWeight(); weight != 2 {
+		t.Errorf("expected the agent to halve the weight, got %.2f", weight)
+	}
+
+	if !service.Available() || !selects() {
+		t.Fatal("expected the service to be selected before it is drained")
+	}
+
+	agent.set("drain")
+	service.runAgentCheck(context.Background())
+	if service.Available() || !service.acceptsPersistentConnections() {
+		t.Error("expected a drained service to only accept pinned clients")
+	}
+
+	if selects() {
+		t.Error("expected a drained service not to be selected")
+	}
+
+	agent.set("ready")
+	service.runAgentCheck(context.Background())
+	if !selects() {
+		t.Error("expected the service to be selected again once it is ready")
+	}
+
+	// An unreachable agent leaves the service as it is
+	service.AgentCheck.Port = 1
+	service.runAgentCheck(context.Background())
+	if !service.Available() {
+		t.Error("expected a failing agent not to change the service")
+	}
+
+	service.AgentCheck.Port = agentService.Port
+	agent.set("down")
+	service.runAgentCheck(context.Background())
+	if service.FSM.CurrentState != StateDown {
+		t.Errorf("expected the agent to take the service DOWN, got %s", service.FSM.CurrentState)
+	}
+}
+
+func TestAgentWeightReshapesHashRing(t *testing.T) {
+	services := newTestServices(2)
+	selector, err := NewConsistentHashSelector(services, HashKeySourceIP, defaultRingReplicas)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// share returns the fraction of keys sent to the first service
+	share := func() float64 {
+		count := 0
+		for i := 0; i < 10000; i++ {
+			if selector.SelectServiceByKey(fmt.Sprintf("client-%d", i)) == services[0] {
+				count++
+			}
+		}
+
+		return float64(count) / 10000
+	}
+
+	services[0].applyAgentReport(AgentReport{WeightPercent: 10, WeightReported: true})
+	if shed := share(); shed > 0.2 {
+		t.Errorf("expected the agent weight to shrink the share of the service on the ring, got %.2f", shed)
+	}
+
+	services[0].applyAgentReport(AgentReport{WeightPercent: 100, WeightReported: true})
+	if restored := share(); restored < 0.35 || restored > 0.65 {
+		t.Errorf("expected the service to get its share back, got %.2f", restored)
+	}
+}
@@ -1 +1,220 @@
 package services
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// defaultRingReplicas is the default number of virtual nodes placed on the ring for every unit of weight
+	defaultRingReplicas = 100
+
+	// defaultLoadFactor is the default multiple of the average load a service may reach with bounded loads
+	defaultLoadFactor = 1.25
+)
+
+// ringNode is a virtual node of a service placed on the hash ring
+type ringNode struct {
+	hash    uint64
+	service *Service
+}
+
+// hashRing is a sorted ring of virtual nodes
+type hashRing []ringNode
+
+// ringNodes returns the number of virtual nodes the service gets, replicas for every unit of its effective weight
+func ringNodes(svc *Service, replicas int) int {
+	return int(math.Round(svc.EffectiveWeight() * float64(replicas)))
+}
+
+// ringSnapshot is a hash ring along with the number of virtual nodes every service had when it was built
+type ringSnapshot struct {
+	nodes []int
+	ring  hashRing
+}
+
+// weightedRing keeps a hash ring in line with the effective weights of its services, it is rebuilt whenever
+// the number of virtual nodes of a service changes, such as during slow start or after its agent reported a
+// new weight. Virtual nodes are derived from the address of the service and their index, so a rebuild only
+// moves keys to or from the services whose weight changed
+type weightedRing struct {
+	services []*Service
+	replicas int
+	snapshot atomic.Pointer[ringSnapshot]
+	mu       sync.Mutex
+}
+
+func newWeightedRing(services []*Service, replicas int) (*weightedRing, error) {
+	if replicas < 1 {
+		return nil, errors.New("replicas must be a positive integer")
+	}
+
+	wr := &weightedRing{services: services, replicas: replicas}
+	wr.rebuild()
+
+	return wr, nil
+}
+
+// current returns the ring for the current weights of the services, rebuilding it if they changed
+func (wr *weightedRing) current() hashRing {
+	if snapshot := wr.snapshot.Load(); wr.matches(snapshot) {
+		return snapshot.ring
+	}
+
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	// Another selection may have rebuilt the ring while this one waited for the lock
+	if snapshot := wr.snapshot.Load(); wr.matches(snapshot) {
+		return snapshot.ring
+	}
+
+	return wr.rebuild()
+}
+
+// matches reports whether every service still has as many virtual nodes as it had in the snapshot
+func (wr *weightedRing) matches(snapshot *ringSnapshot) bool {
+	for i, svc := range wr.services {
+		if ringNodes(svc, wr.replicas) != snapshot.nodes[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// rebuild places every service on the ring with the number of virtual nodes its current weight gives it
+func (wr *weightedRing) rebuild() hashRing {
+	nodes := make([]int, len(wr.services))
+	total := 0
+	for i, svc := range wr.services {
+		nodes[i] = ringNodes(svc, wr.replicas)
+		total += nodes[i]
+	}
+
+	ring := make(hashRing, 0, total)
+	for i, svc := range wr.services {
+		for j := 0; j < nodes[i]; j++ {
+			ring = append(ring, ringNode{
+				hash:    hashKey(fmt.Sprintf("%s-%d", svc.Address(), j)),
+				service: svc,
+			})
+		}
+	}
+
+	slices.SortFunc(ring, func(a, b ringNode) int { return cmp.Compare(a.hash, b.hash) })
+	wr.snapshot.Store(&ringSnapshot{nodes: nodes, ring: ring})
+
+	return ring
+}
+
+// find walks the ring clockwise from the hash of the key and returns the first alive service accepted by
+// the filter, a nil filter accepts every alive service
+func (r hashRing) find(key string, filter func(svc *Service) bool) *Service {
+	if len(r) == 0 {
+		return nil
+	}
+
+	hash := hashKey(key)
+	start := sort.Search(len(r), func(i int) bool { return r[i].hash >= hash })
+
+	for i := 0; i < len(r); i++ {
+		svc := r[(start+i)%len(r)].service
+		if svc.Available() && (filter == nil || filter(svc)) {
+			return svc
+		}
+	}
+
+	return nil // No healthy service is on the ring
+}
+
+// ConsistentHashSelector places every service on a hash ring as a set of virtual nodes, proportional to
+// its effective weight, and selects the first alive service clockwise from the hash of the request key.
+// Services that aren't alive are skipped rather than removed, so a service going down only remaps the keys
+// it owned and those keys return to it once it is alive again
+type ConsistentHashSelector struct {
+	ring *weightedRing
+	key  HashKey
+}
+
+func NewConsistentHashSelector(services []*Service, key HashKey, replicas int) (*ConsistentHashSelector, error) {
+	ring, err := newWeightedRing(services, replicas)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConsistentHashSelector{ring: ring, key: key}, nil
+}
+
+func (ch *ConsistentHashSelector) SelectService(req *SelectionRequest) *Service {
+	return ch.SelectServiceByKey(req.Key(ch.key))
+}
+
+// SelectServiceByKey selects the first alive service on the ring clockwise from the hash of the key
+func (ch *ConsistentHashSelector) SelectServiceByKey(key string) *Service {
+	return ch.ring.current().find(key, nil)
+}
+
+// BoundedLoadSelector implements consistent hashing with bounded loads, no service may hold more than
+// loadFactor times its share of the active connections (including the one being selected for). A key whose
+// service is at capacity walks the ring to the next service with room, so a handful of busy clients can't
+// overload a single service while every other key keeps its affinity
+type BoundedLoadSelector struct {
+	ring       *weightedRing
+	services   []*Service
+	key        HashKey
+	loadFactor float64
+}
+
+func NewBoundedLoadSelector(services []*Service, key HashKey, replicas int, loadFactor float64) (*BoundedLoadSelector, error) {
+	if loadFactor < 1 {
+		return nil, errors.New("load_factor must be at least 1")
+	}
+
+	ring, err := newWeightedRing(services, replicas)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BoundedLoadSelector{
+		ring:       ring,
+		services:   services,
+		key:        key,
+		loadFactor: loadFactor,
+	}, nil
+}
+
+func (bl *BoundedLoadSelector) SelectService(req *SelectionRequest) *Service {
+	return bl.SelectServiceByKey(req.Key(bl.key))
+}
+
+// SelectServiceByKey selects the first alive service clockwise from the hash of the key that is below its capacity
+func (bl *BoundedLoadSelector) SelectServiceByKey(key string) *Service {
+	var connections int64
+	var weights float64
+	for _, svc := range bl.services {
+		if svc.Available() {
+			connections += svc.ActiveConnections()
+			weights += svc.EffectiveWeight()
+		}
+	}
+
+	if weights <= 0 {
+		return nil
+	}
+
+	// Capacity is shared by weight and includes the connection being selected for, so the sum of every
+	// capacity is always above the total load and at least one service has room
+	perWeight := bl.loadFactor * float64(connections+1) / weights
+
+	return bl.ring.current().find(key, func(svc *Service) bool {
+		capacity := math.Ceil(perWeight * svc.EffectiveWeight())
+		return float64(svc.ActiveConnections()) < capacity
+	})
+}
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestConsistentHashSelectorIsSticky(t *testing.T) {
+	selector, err := NewConsistentHashSelector(newTestServices(5), HashKeySourceIP, defaultRingReplicas)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("10.0.0.%d", i)
+		first := selector.SelectServiceByKey(key)
+		if first == nil {
+			t.Fatal("service is nil")
+		}
+
+		if again := selector.SelectServiceByKey(key); again != first {
+			t.Errorf("key %s moved from %s to %s", key, first.Name, again.Name)
+		}
+	}
+}
+
+func TestConsistentHashSelectorHashesConfiguredKey(t *testing.T) {
+	selector, err := NewSelector("consistent-hashing", newTestServices(5), SelectorOptions{"key": "sni"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every client asking for the same server name lands on the same service
+	var expected *Service
+	for i := 0; i < 100; i++ {
+		req := newTestRequest(i)
+		req.Metadata.SNI = "api.example.com"
+
+		selected := selector.SelectService(req)
+		if expected == nil {
+			expected = selected
+		}
+
+		if selected == nil || selected != expected {
+			t.Fatalf("expected every request for the same SNI to select the same service")
+		}
+	}
+
+	if _, err := NewSelector("bounded-load-consistent-hashing", newTestServices(5), SelectorOptions{"key": "cookie"}); err == nil {
+		t.Error("expected an unknown key to be rejected")
+	}
+}
+
+func TestConsistentHashSelectorOnlyRemapsAffectedKeys(t *testing.T) {
+	services := newTestServices(5)
+	selector, err := NewConsistentHashSelector(services, HashKeySourceIP, defaultRingReplicas)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := map[string]*Service{}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+		before[key] = selector.SelectServiceByKey(key)
+	}
+
+	down := services[2]
+	down.FSM.CurrentState = StateDown
+
+	for key, previous := range before {
+		current := selector.SelectServiceByKey(key)
+		if current == down {
+			t.Fatalf("key %s was sent to a service which is down", key)
+		}
+
+		if previous != down && current != previous {
+			t.Errorf("key %s moved from %s to %s although its service is alive", key, previous.Name, current.Name)
+		}
+	}
+
+	down.FSM.CurrentState = StateAlive
+	for key, previous := range before {
+		if current := selector.SelectServiceByKey(key); current != previous {
+			t.Errorf("key %s did not return to %s after recovery, got %s", key, previous.Name, current.Name)
+		}
+	}
+}
+
+func TestConsistentHashSelectorHonorsWeights(t *testing.T) {
+	services := newTestServices(2)
+	services[0].Weight = 3
+
+	selector, err := NewConsistentHashSelector(services, HashKeySourceIP, defaultRingReplicas)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := map[*Service]int{}
+	for i := 0; i < 10000; i++ {
+		counts[selector.SelectServiceByKey(fmt.Sprintf("client-%d", i))]++
+	}
+
+	ratio := float64(counts[services[0]]) / float64(counts[services[1]])
+	if ratio < 2.4 || ratio > 3.6 {
+		t.Errorf("expected roughly a 3:1 split, got %d:%d", counts[services[0]], counts[services[1]])
+	}
+}
+
+func TestBoundedLoadSelectorCapsLoad(t *testing.T) {
+	services := newTestServices(4)
+	selector, err := NewBoundedLoadSelector(services, HashKeySourceIP, defaultRingReplicas, 1.25)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A single hot client opens every connection, plain consistent hashing would send all of them to one service
+	for i := 0; i < 400; i++ {
+		service := selector.SelectServiceByKey("10.0.0.1")
+		if service == nil {
+			t.Fatal("service is nil")
+		}
+
+		service.AcquireConnection()
+	}
+
+	for _, service := range services {
+		if count := service.ActiveConnections(); count > 125 {
+			t.Errorf("expected %s to stay below 125 connections, got %d", service.Name, count)
+		}
+	}
+}
+
+func TestBoundedLoadSelectorKeepsAffinityBelowCapacity(t *testing.T) {
+	services := newTestServices(5)
+	plain, err := NewConsistentHashSelector(services, HashKeySourceIP, defaultRingReplicas)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bounded, err := NewBoundedLoadSelector(services, HashKeySourceIP, defaultRingReplicas, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("10.0.0.%d", i)
+		if expected, got := plain.SelectServiceByKey(key), bounded.SelectServiceByKey(key); expected != got {
+			t.Errorf("expected key %s to stay on %s without load, got %s", key, expected.Name, got.Name)
+		}
+	}
+}
+
+func TestBoundedLoadSelectorRequiresLoadFactor(t *testing.T) {
+	if _, err := NewBoundedLoadSelector(newTestServices(2), HashKeySourceIP, defaultRingReplicas, 0.5); err == nil {
+		t.Error("expected an error for a load factor below 1")
+	}
+}
+
+func TestConsistentHashSelectorFollowsSlowStart(t *testing.T) {
+	services := newTestServices(2)
+	warming := services[1]
+	warming.SlowStart = time.Minute
+	warming.FSM.CurrentState = StateDown
+	sendTestEvent(t, warming, EventSuccess)
+
+	selector, err := NewConsistentHashSelector(services, HashKeySourceIP, defaultRingReplicas)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// share returns the fraction of keys sent to the warming service
+	share := func() float64 {
+		count := 0
+		for i := 0; i < 10000; i++ {
+			if selector.SelectServiceByKey(fmt.Sprintf("client-%d", i)) == warming {
+				count++
+			}
+		}
+
+		return float64(count) / 10000
+	}
+
+	if ramping := share(); ramping > 0.2 {
+		t.Errorf("expected a service in slow start to get a small share of the keys, got %.2f", ramping)
+	}
+
+	warming.metadataMu.Lock()
+	warming.Metadata.AliveSince = time.Now().Add(-time.Hour)
+	warming.metadataMu.Unlock()
+
+	if warmed := share(); warmed < 0.35 || warmed > 0.65 {
+		t.Errorf("expected the ring to be rebuilt with the full weight once slow start is over, got %.2f", warmed)
+	}
+}
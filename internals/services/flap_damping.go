@@ -0,0 +1,146 @@
+package services
+
+import (
+	"math"
+	"time"
+
+	"github.com/frostzt/splitbit/internals"
+)
+
+// maxTransitionHistory is how many of its latest transitions a service remembers
+const maxTransitionHistory = 32
+
+// Transition is a change of state of a service
+type Transition struct {
+	From internals.StateType
+	To   internals.StateType
+	Time time.Time
+}
+
+// FlapDampingOptions configures how services going DOWN over and over again are held back. Every time the
+// service goes from ALIVE to DOWN its flap score grows by one, and the score halves every HalfLife
+type FlapDampingOptions struct {
+	// HalfLife is how long it takes for the flap score to decay by half
+	HalfLife time.Duration
+
+	// SuppressThreshold is the flap score at which the service is suppressed
+	SuppressThreshold float64
+
+	// ReuseThreshold is the flap score below which a suppressed service may recover again
+	ReuseThreshold float64
+
+	// MaxSuppressTime caps how long the service stays suppressed however much it flapped
+	MaxSuppressTime time.Duration
+}
+
+// flapDamping keeps the flap score of a service, it is guarded by the metadataMu of the service
+type flapDamping struct {
+	opts    FlapDampingOptions
+	score   float64
+	updated time.Time
+}
+
+// newFlapDamping fills the options which were left empty with their defaults, MaxSuppressTime is raised if
+// the score couldn't reach the suppress threshold otherwise
+func newFlapDamping(opts FlapDampingOptions) *flapDamping {
+	if opts.HalfLife <= 0 {
+		opts.HalfLife = internals.DefaultFlapHalfLife
+	}
+
+	if opts.SuppressThreshold <= 0 {
+		opts.SuppressThreshold = internals.DefaultFlapSuppressThreshold
+	}
+
+	if opts.ReuseThreshold <= 0 || opts.ReuseThreshold >= opts.SuppressThreshold {
+		opts.ReuseThreshold = min(internals.DefaultFlapReuseThreshold, opts.SuppressThreshold/2)
+	}
+
+	if opts.MaxSuppressTime <= 0 {
+		opts.MaxSuppressTime = internals.DefaultFlapMaxSuppressTime
+	}
+
+	opts.MaxSuppressTime = max(opts.MaxSuppressTime, internals.MinFlapSuppressTime(opts.HalfLife, opts.SuppressThreshold, opts.ReuseThreshold))
+
+	return &flapDamping{opts: opts}
+}
+
+// current returns the flap score decayed up to now
+func (f *flapDamping) current(now time.Time) float64 {
+	if f.score == 0 {
+		return 0
+	}
+
+	elapsed := max(now.Sub(f.updated), 0)
+	return f.score * math.Exp2(-float64(elapsed)/float64(f.opts.HalfLife))
+}
+
+// penalize counts a flap at now and returns the new score, which is capped so the score decays below the
+// reuse threshold within MaxSuppressTime
+func (f *flapDamping) penalize(now time.Time) float64 {
+	ceiling := f.opts.ReuseThreshold * math.Exp2(float64(f.opts.MaxSuppressTime)/float64(f.opts.HalfLife))
+
+	f.score = min(f.current(now)+1, ceiling)
+	f.updated = now
+
+	return f.score
+}
+
+// recordTransition adds the transition the FSM just went through to the history of the service
+func (s *Service) recordTransition() {
+	s.metadataMu.Lock()
+	defer s.metadataMu.Unlock()
+
+	if len(s.transitions) == maxTransitionHistory {
+		s.transitions = append(s.transitions[:0], s.transitions[1:]...)
+	}
+
+	s.transitions = append(s.transitions, Transition{From: s.FSM.PreviousState, To: s.FSM.CurrentState, Time: time.Now()})
+}
+
+// Transitions returns the latest transitions of the service, oldest first
+func (s *Service) Transitions() []Transition {
+	s.metadataMu.RLock()
+	defer s.metadataMu.RUnlock()
+
+	return append([]Transition(nil), s.transitions...)
+}
+
+// FlapScore returns the current flap score of the service, always 0 without flap damping
+func (s *Service) FlapScore() float64 {
+	if s.flapDamping == nil {
+		return 0
+	}
+
+	s.metadataMu.RLock()
+	defer s.metadataMu.RUnlock()
+
+	return s.flapDamping.current(time.Now())
+}
+
+// countFlap penalizes the service for going DOWN and reports whether it should be suppressed, metadataMu must
+// be held by the caller
+func (s *Service) countFlap(now time.Time) bool {
+	if s.flapDamping == nil {
+		return false
+	}
+
+	score := s.flapDamping.penalize(now)
+	if score < s.flapDamping.opts.SuppressThreshold {
+		s.Logger.Info("Service %s flapped, its flap score is %.2f", s.Name, score)
+		return false
+	}
+
+	return true
+}
+
+// reuseDue reports whether the service is SUPPRESSED and its flap score decayed below the reuse threshold
+func (s *Service) reuseDue(now time.Time) bool {
+	if s.flapDamping == nil || s.FSM.CurrentState != StateSuppressed {
+		return false
+	}
+
+	s.metadataMu.RLock()
+	defer s.metadataMu.RUnlock()
+
+	return s.flapDamping.current(now) < s.flapDamping.opts.ReuseThreshold
+}
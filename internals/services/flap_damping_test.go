@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/frostzt/splitbit/internals"
+)
+
+func TestFlapScoreDecays(t *testing.T) {
+	damping := newFlapDamping(FlapDampingOptions{HalfLife: time.Minute, ReuseThreshold: 1, MaxSuppressTime: 3 * time.Minute})
+	now := time.Now()
+
+	damping.penalize(now)
+	damping.penalize(now)
+	if score := damping.current(now.Add(time.Minute)); math.Abs(score-1) > 1e-9 {
+		t.Errorf("expected the score to halve after a half life, got %.2f", score)
+	}
+
+	// The score never grows beyond what decays below the reuse threshold within MaxSuppressTime
+	for i := 0; i < 20; i++ {
+		damping.penalize(now)
+	}
+
+	if score := damping.current(now); score != 8 {
+		t.Errorf("expected the score to be capped at 8, got %.2f", score)
+	}
+}
+
+func TestFlapScoreCapReachesSuppressThreshold(t *testing.T) {
+	// A cap of 2^(5m/10m) would stay below the threshold of 3 forever
+	damping := newFlapDamping(FlapDampingOptions{HalfLife: 10 * time.Minute, MaxSuppressTime: 5 * time.Minute})
+	if damping.opts.MaxSuppressTime < 15*time.Minute {
+		t.Errorf("expected the maximum suppress time to be raised, got %s", damping.opts.MaxSuppressTime)
+	}
+
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		damping.penalize(now)
+	}
+
+	if score := damping.current(now); score < damping.opts.SuppressThreshold {
+		t.Errorf("expected the score to reach the suppress threshold, got %.2f", score)
+	}
+}
+
+func TestFlappingServiceIsSuppressed(t *testing.T) {
+	checker := &stubHealthChecker{}
+	service := newTestService("flapping", StateAlive, 1)
+	service.HealthChecker = checker
+	service.HealthCheckTimeout = time.Second
+	service.HealthCheckRise = 1
+	service.HealthCheckFall = 1
+	service.flapDamping = newFlapDamping(FlapDampingOptions{HalfLife: time.Minute, SuppressThreshold: 1.5, ReuseThreshold: 1})
+
+	// The first time the service goes down it is only penalized
+	checker.err = errors.New("connection refused")
+	service.runHealthCheck(context.Background())
+	if service.FSM.CurrentState != StateDown {
+		t.Fatalf("expected the service to be DOWN after its first flap, got %s", service.FSM.CurrentState)
+	}
+
+	checker.err = nil
+	service.runHealthCheck(context.Background())
+	if service.FSM.CurrentState != StateAlive {
+		t.Fatalf("expected the service to recover, got %s", service.FSM.CurrentState)
+	}
+
+	checker.err = errors.New("connection refused")
+	service.runHealthCheck(context.Background())
+	if service.FSM.CurrentState != StateSuppressed {
+		t.Fatalf("expected the service to be SUPPRESSED after flapping twice, got %s", service.FSM.CurrentState)
+	}
+
+	if score := service.FlapScore(); score < 1.9 {
+		t.Errorf("expected a flap score of about 2, got %.2f", score)
+	}
+
+	// A suppressed service isn't probed however healthy it looks
+	checker.err, checker.calls = nil, 0
+	service.runHealthCheck(context.Background())
+	if checker.calls != 0 || service.FSM.CurrentState != StateSuppressed {
+		t.Fatalf("expected no probe while suppressed, got %d probes and state %s", checker.calls, service.FSM.CurrentState)
+	}
+
+	// Once the score decays below the reuse threshold the service gets a recovery attempt
+	service.flapDamping.updated = service.flapDamping.updated.Add(-2 * time.Minute)
+	service.runHealthCheck(context.Background())
+	if checker.calls != 1 || service.FSM.CurrentState != StateAlive {
+		t.Fatalf("expected the service to recover once its score decayed, got %d probes and state %s", checker.calls, service.FSM.CurrentState)
+	}
+
+	expected := []struct{ from, to internals.StateType }{
+		{StateAlive, StateDown}, {StateDown, StateHalfOpen}, {StateHalfOpen, StateAlive},
+		{StateAlive, StateDown}, {StateDown, StateSuppressed},
+		{StateSuppressed, StateHalfOpen}, {StateHalfOpen, StateAlive},
+	}
+
+	transitions := service.Transitions()
+	if len(transitions) != len(expected) {
+		t.Fatalf("expected %d transitions, got %+v", len(expected), transitions)
+	}
+
+	for i, transition := range transitions {
+		if transition.From != expected[i].from || transition.To != expected[i].to {
+			t.Errorf("expected transition %d to be %s -> %s, got %s -> %s", i, expected[i].from, expected[i].to, transition.From, transition.To)
+		}
+	}
+}
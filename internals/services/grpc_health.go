@@ -0,0 +1,241 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// grpcHealthCheckPath is the method of the gRPC health checking protocol, grpc.health.v1.Health/Check
+const grpcHealthCheckPath = "/grpc.health.v1.Health/Check"
+
+// grpcMaxResponseSize caps the size of the response message, a HealthCheckResponse only holds a status
+const grpcMaxResponseSize = 4 * 1024
+
+// grpcServingStatus is the status of a grpc.health.v1.HealthCheckResponse
+type grpcServingStatus uint64
+
+const (
+	grpcStatusUnknown        grpcServingStatus = 0
+	grpcStatusServing        grpcServingStatus = 1
+	grpcStatusNotServing     grpcServingStatus = 2
+	grpcStatusServiceUnknown grpcServingStatus = 3
+)
+
+func (s grpcServingStatus) String() string {
+	switch s {
+	case grpcStatusUnknown:
+		return "UNKNOWN"
+	case grpcStatusServing:
+		return "SERVING"
+	case grpcStatusNotServing:
+		return "NOT_SERVING"
+	case grpcStatusServiceUnknown:
+		return "SERVICE_UNKNOWN"
+	default:
+		return fmt.Sprintf("status %d", uint64(s))
+	}
+}
+
+// GRPCHealthChecker considers a service healthy if grpc.health.v1.Health/Check reports it as SERVING. The
+// protocol is spoken directly over HTTP/2, in cleartext or over TLS, so no gRPC library is needed for a call
+// this small
+type GRPCHealthChecker struct {
+	// Service is the name sent in the request, an empty name checks the overall health of the server
+	Service string
+
+	// Port overrides the port of the service when set
+	Port int
+
+	// Scheme is https when the checker was created with a TLS configuration and http otherwise
+	Scheme string
+
+	client *http.Client
+}
+
+// NewGRPCHealthChecker creates a checker for the gRPC service name, probing over TLS when tlsConfig is not nil
+func NewGRPCHealthChecker(service string, tlsConfig *tls.Config) *GRPCHealthChecker {
+	scheme := "http"
+	protocols := new(http.Protocols)
+	if tlsConfig != nil {
+		scheme = "https"
+		protocols.SetHTTP2(true)
+	} else {
+		protocols.SetUnencryptedHTTP2(true)
+	}
+
+	return &GRPCHealthChecker{
+		Service: service,
+		Scheme:  scheme,
+		client: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig:     tlsConfig,
+				Protocols:           protocols,
+				MaxIdleConnsPerHost: 1,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		},
+	}
+}
+
+func (c *GRPCHealthChecker) Check(ctx context.Context, svc *Service) error {
+	url := fmt.Sprintf("%s://%s%s", c.Scheme, checkAddress(svc, c.Port), grpcHealthCheckPath)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(encodeGRPCHealthCheckRequest(c.Service)))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set("Grpc-Timeout", fmt.Sprintf("%dm", max(time.Until(deadline).Milliseconds(), 1)))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected gRPC health check HTTP status %d", resp.StatusCode)
+	}
+
+	// Servers failing the call right away send the status in the headers without any message
+	if err := grpcStatusError(resp.Header); err != nil {
+		return err
+	}
+
+	message, err := readGRPCMessage(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	// The trailers are only available once the body has been read entirely
+	if _, err := io.Copy(io.Discard, io.LimitReader(resp.Body, grpcMaxResponseSize)); err != nil {
+		return err
+	}
+
+	if err := grpcStatusError(resp.Trailer); err != nil {
+		return err
+	}
+
+	status, err := decodeGRPCHealthCheckResponse(message)
+	if err != nil {
+		return err
+	}
+
+	if status != grpcStatusServing {
+		return fmt.Errorf("gRPC health check reported %s", status)
+	}
+
+	return nil
+}
+
+// grpcStatusError returns the error described by the grpc-status and grpc-message of the headers, if any
+func grpcStatusError(header http.Header) error {
+	status := header.Get("Grpc-Status")
+	if status == "" || status == "0" {
+		return nil
+	}
+
+	return fmt.Errorf("gRPC health check failed with status %s: %s", status, header.Get("Grpc-Message"))
+}
+
+// encodeGRPCHealthCheckRequest encodes a grpc.health.v1.HealthCheckRequest into a length-prefixed gRPC
+// message, the service name is its only field
+func encodeGRPCHealthCheckRequest(service string) []byte {
+	var message []byte
+	if service != "" {
+		// Field 1 with the length-delimited wire type
+		message = append(message, 1<<3|2)
+		message = binary.AppendUvarint(message, uint64(len(service)))
+		message = append(message, service...)
+	}
+
+	// The prefix is an uncompressed flag followed by the length of the message
+	framed := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(framed[1:], uint32(len(message)))
+
+	return append(framed, message...)
+}
+
+// readGRPCMessage reads a single length-prefixed gRPC message
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, fmt.Errorf("failed to read gRPC health check response: %w", err)
+	}
+
+	if prefix[0] != 0 {
+		return nil, errors.New("compressed gRPC health check responses are not supported")
+	}
+
+	length := binary.BigEndian.Uint32(prefix[1:])
+	if length > grpcMaxResponseSize {
+		return nil, fmt.Errorf("gRPC health check response exceeds %d bytes", grpcMaxResponseSize)
+	}
+
+	message := make([]byte, length)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, fmt.Errorf("failed to read gRPC health check response: %w", err)
+	}
+
+	return message, nil
+}
+
+// decodeGRPCHealthCheckResponse decodes the status of a grpc.health.v1.HealthCheckResponse, unknown fields
+// are skipped and a missing status is UNKNOWN like protobuf defaults it
+func decodeGRPCHealthCheckResponse(message []byte) (grpcServingStatus, error) {
+	status := grpcStatusUnknown
+
+	for len(message) > 0 {
+		tag, n := binary.Uvarint(message)
+		if n <= 0 {
+			return 0, errors.New("malformed gRPC health check response")
+		}
+		message = message[n:]
+
+		field, wireType := tag>>3, tag&7
+		switch wireType {
+		case 0:
+			value, n := binary.Uvarint(message)
+			if n <= 0 {
+				return 0, errors.New("malformed gRPC health check response")
+			}
+			message = message[n:]
+
+			if field == 1 {
+				status = grpcServingStatus(value)
+			}
+		case 1:
+			if len(message) < 8 {
+				return 0, errors.New("malformed gRPC health check response")
+			}
+			message = message[8:]
+		case 2:
+			length, n := binary.Uvarint(message)
+			if n <= 0 || uint64(len(message)-n) < length {
+				return 0, errors.New("malformed gRPC health check response")
+			}
+			message = message[n+int(length):]
+		case 5:
+			if len(message) < 4 {
+				return 0, errors.New("malformed gRPC health check response")
+			}
+			message = message[4:]
+		default:
+			return 0, fmt.Errorf("unsupported wire type %d in gRPC health check response", wireType)
+		}
+	}
+
+	return status, nil
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/frostzt/splitbit/internals"
+)
+
+// newTestGRPCHealthServer starts a server implementing grpc.health.v1.Health/Check, reporting the status
+// of the services it knows and NOT_FOUND for the others
+func newTestGRPCHealthServer(t *testing.T, statuses map[string]grpcServingStatus, useTLS bool) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || r.URL.Path != grpcHealthCheckPath || r.Header.Get("Content-Type") != "application/grpc" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil || len(body) < 5 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// The service name is the only field of the request, tagged 0x0a with a single byte length
+		var service string
+		if message := body[5:]; len(message) > 2 {
+			service = string(message[2:])
+		}
+
+		w.Header().Set("Content-Type", "application/grpc")
+
+		status, ok := statuses[service]
+		if !ok {
+			w.Header().Set("Grpc-Status", "5")
+			w.Header().Set("Grpc-Message", "unknown service")
+			return
+		}
+
+		w.Header().Set("Trailer", "Grpc-Status")
+		_, _ = w.Write([]byte{0, 0, 0, 0, 2, 1<<3 | 0, byte(status)})
+		w.Header().Set("Grpc-Status", "0")
+	}))
+
+	if useTLS {
+		server.EnableHTTP2 = true
+		server.StartTLS()
+	} else {
+		server.Config.Protocols = new(http.Protocols)
+		server.Config.Protocols.SetUnencryptedHTTP2(true)
+		server.Start()
+	}
+
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGRPCHealthChecker(t *testing.T) {
+	statuses := map[string]grpcServingStatus{"": grpcStatusServing, "orders": grpcStatusServing, "payments": grpcStatusNotServing}
+	server := newTestGRPCHealthServer(t, statuses, false)
+	service := newTestServiceFor(t, server.Listener.Addr().String())
+
+	tests := []struct {
+		name    string
+		healthy bool
+	}{
+		{"", true},
+		{"orders", true},
+		{"payments", false},
+		{"inventory", false},
+	}
+
+	for _, test := range tests {
+		err := checkTestService(NewGRPCHealthChecker(test.name, nil), service)
+		if test.healthy && err != nil {
+			t.Errorf("expected service %q to be healthy, got %v", test.name, err)
+		} else if !test.healthy && err == nil {
+			t.Errorf("expected service %q to be unhealthy", test.name)
+		}
+	}
+}
+
+func TestGRPCHealthCheckerOverTLS(t *testing.T) {
+	server := newTestGRPCHealthServer(t, map[string]grpcServingStatus{"orders": grpcStatusServing}, true)
+	service := newTestServiceFor(t, server.Listener.Addr().String())
+
+	checker, err := NewHealthChecker(internals.HealthCheckConfig{
+		Type:        HealthCheckGRPC,
+		GRPCService: "orders",
+		TLS:         internals.HealthCheckTLSConfig{InsecureSkipVerify: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkTestService(checker, service); err != nil {
+		t.Errorf("expected the check to pass over TLS, got %v", err)
+	}
+
+	if err := checkTestService(NewGRPCHealthChecker("orders", &tls.Config{}), service); err == nil {
+		t.Error("expected the check to fail against an untrusted certificate")
+	}
+}
+
+func TestDecodeGRPCHealthCheckResponse(t *testing.T) {
+	// An unknown length-delimited field followed by the status
+	status, err := decodeGRPCHealthCheckResponse([]byte{2<<3 | 2, 2, 'h', 'i', 1<<3 | 0, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status != grpcStatusNotServing {
+		t.Errorf("expected NOT_SERVING, got %s", status)
+	}
+
+	if status, _ := decodeGRPCHealthCheckResponse(nil); status != grpcStatusUnknown {
+		t.Errorf("expected an empty response to be UNKNOWN, got %s", status)
+	}
+
+	if _, err := decodeGRPCHealthCheckResponse([]byte{2<<3 | 2, 10, 'h'}); err == nil {
+		t.Error("expected an error for a truncated field")
+	}
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	"github.com/frostzt/splitbit/internals"
+)
+
+// HashKey names the part of a connection hashing selectors derive their key from
+type HashKey string
+
+const (
+	// HashKeySourceIP keys connections on the IP address of the client
+	HashKeySourceIP HashKey = "source-ip"
+
+	// HashKeySourceIPPort keys connections on the IP address and port of the client
+	HashKeySourceIPPort HashKey = "source-ip-port"
+
+	// HashKeyFiveTuple keys connections on the protocol and both addresses, every connection is hashed
+	// independently so this spreads a single client across services
+	HashKeyFiveTuple HashKey = "five-tuple"
+
+	// HashKeySNI keys connections on the sniffed TLS server name
+	HashKeySNI HashKey = "sni"
+
+	// HashKeyHost keys connections on the sniffed HTTP Host header
+	HashKeyHost HashKey = "host"
+)
+
+// hashKeys contains every supported HashKey
+var hashKeys = []HashKey{HashKeySourceIP, HashKeySourceIPPort, HashKeyFiveTuple, HashKeySNI, HashKeyHost}
+
+func init() {
+	for _, key := range hashKeys {
+		internals.RegisterHashKey(string(key))
+	}
+}
+
+// ParseHashKey parses the name of a HashKey
+func ParseHashKey(value string) (HashKey, error) {
+	for _, key := range hashKeys {
+		if string(key) == value {
+			return key, nil
+		}
+	}
+
+	return "", fmt.Errorf("unsupported hash key %q, supported keys are %v", value, hashKeys)
+}
+
+// hashKey hashes the key into a well distributed 64-bit value, FNV-1a alone distributes similar keys
+// (such as neighbouring IP addresses) poorly so its output is passed through the splitmix64 finalizer
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+
+	return mix64(h.Sum64())
+}
+
+// mix64 is the splitmix64 finalizer which spreads every input bit across the output
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+
+	return x
+}
@@ -0,0 +1,368 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/frostzt/splitbit/internals"
+)
+
+const (
+	// HealthCheckTCP probes a service by opening a TCP connection to it
+	HealthCheckTCP = "tcp"
+
+	// HealthCheckHTTP probes a service with an HTTP request to its health check path
+	HealthCheckHTTP = "http"
+
+	// HealthCheckGRPC probes a service with the gRPC health checking protocol
+	HealthCheckGRPC = "grpc"
+
+	// HealthCheckRedis probes a service with a Redis PING, optionally requiring a replication role
+	HealthCheckRedis = "redis"
+
+	// HealthCheckPostgres probes a service by logging into PostgreSQL and running a simple query
+	HealthCheckPostgres = "postgres"
+
+	// HealthCheckMySQL probes a service by logging into MySQL and pinging it
+	HealthCheckMySQL = "mysql"
+
+	// HealthCheckExec probes a service by running an external command
+	HealthCheckExec = "exec"
+)
+
+// defaultMaxResponseSize is how much of the response body HTTP checks read unless configured otherwise
+const defaultMaxResponseSize = 64 * 1024
+
+// HealthChecker probes a service, a nil error means the service is healthy. Checks must give up once the
+// context is done
+type HealthChecker interface {
+	Check(ctx context.Context, svc *Service) error
+}
+
+// NewHealthChecker builds the health checker described by the backend configuration
+func NewHealthChecker(cfg internals.HealthCheckConfig) (HealthChecker, error) {
+	switch cfg.Type {
+	case HealthCheckTCP, "":
+		return &TCPHealthChecker{Port: cfg.Port}, nil
+	case HealthCheckHTTP:
+		tlsConfig, err := healthCheckTLSConfigFor(cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		checker := NewHTTPHealthChecker(cfg.Path, tlsConfig)
+		checker.Port = cfg.Port
+		checker.Host = cfg.Host
+		checker.Headers = cfg.Headers
+		checker.ExpectStatus = cfg.ExpectStatus
+		checker.ExpectBody = cfg.ExpectBody
+
+		if cfg.Method != "" {
+			checker.Method = strings.ToUpper(cfg.Method)
+		}
+
+		if cfg.MaxResponseSize > 0 {
+			checker.MaxResponseSize = cfg.MaxResponseSize
+		}
+
+		if cfg.ExpectBodyRegex != "" {
+			expression, err := regexp.Compile(cfg.ExpectBodyRegex)
+			if err != nil {
+				return nil, fmt.Errorf("invalid expect_body_regex: %w", err)
+			}
+
+			checker.ExpectBodyRegex = expression
+		}
+
+		return checker, nil
+	case HealthCheckGRPC:
+		tlsConfig, err := healthCheckTLSConfigFor(cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		checker := NewGRPCHealthChecker(cfg.GRPCService, tlsConfig)
+		checker.Port = cfg.Port
+
+		return checker, nil
+	case HealthCheckRedis:
+		return &RedisHealthChecker{Port: cfg.Port, Username: cfg.Username, Password: cfg.Password, Role: cfg.Role}, nil
+	case HealthCheckPostgres:
+		if cfg.Username == "" {
+			return nil, errors.New("postgres health checks require a username")
+		}
+
+		return &PostgresHealthChecker{
+			Port:     cfg.Port,
+			Username: cfg.Username,
+			Password: cfg.Password,
+			Database: cfg.Database,
+			Query:    cfg.Query,
+		}, nil
+	case HealthCheckMySQL:
+		if cfg.Username == "" {
+			return nil, errors.New("mysql health checks require a username")
+		}
+
+		return &MySQLHealthChecker{Port: cfg.Port, Username: cfg.Username, Password: cfg.Password, Database: cfg.Database}, nil
+	case HealthCheckExec:
+		if len(cfg.Command) == 0 {
+			return nil, errors.New("exec health checks require a command")
+		}
+
+		return &ExecHealthChecker{Command: cfg.Command}, nil
+	default:
+		return nil, fmt.Errorf("unsupported health check type %s", cfg.Type)
+	}
+}
+
+// NewHealthCheckTLSConfig builds the TLS configuration checks use to connect to a service
+func NewHealthCheckTLSConfig(cfg internals.HealthCheckTLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CAFile != "" {
+		bundle, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", cfg.CAFile)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
+
+// healthCheckTLSConfigFor returns the TLS configuration of checks using the https scheme, or nil for checks
+// in plaintext
+func healthCheckTLSConfigFor(cfg internals.HealthCheckConfig) (*tls.Config, error) {
+	if cfg.Scheme == "https" || (cfg.Scheme == "" && cfg.TLS.Enabled()) {
+		return NewHealthCheckTLSConfig(cfg.TLS)
+	}
+
+	return nil, nil
+}
+
+// checkAddress returns the address checks connect to, which is the address of the service unless the
+// check has a port of its own
+func checkAddress(svc *Service, port int) string {
+	if port == 0 {
+		return svc.Address()
+	}
+
+	return net.JoinHostPort(svc.Host, strconv.Itoa(port))
+}
+
+// dialHealthCheck connects to the service for protocol checks, every read and write on the connection
+// fails once the context deadline is reached
+func dialHealthCheck(ctx context.Context, svc *Service, port int) (net.Conn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", checkAddress(svc, port))
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// TCPHealthChecker considers a service healthy if a TCP connection to it can be established
+type TCPHealthChecker struct {
+	// Port overrides the port of the service when set
+	Port int
+}
+
+func (c *TCPHealthChecker) Check(ctx context.Context, svc *Service) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", checkAddress(svc, c.Port))
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// HTTPHealthChecker considers a service healthy if a request to its health check path returns an expected
+// status and, when configured, a body containing or matching what is expected. Redirects are not followed
+// so they can be accepted or rejected like any other status. Every checker keeps its own connections alive
+// between checks
+type HTTPHealthChecker struct {
+	Path string
+
+	// Scheme is https when the checker was created with a TLS configuration and http otherwise
+	Scheme string
+
+	// Port overrides the port of the service when set
+	Port int
+
+	// Method defaults to GET
+	Method string
+
+	// Host overrides the Host header, which defaults to the address of the service
+	Host string
+
+	// Headers are added to every request
+	Headers map[string]string
+
+	// ExpectStatus lists the accepted status codes, only a 200 is accepted when it is empty
+	ExpectStatus []internals.StatusRange
+
+	// ExpectBody is a substring the body must contain
+	ExpectBody string
+
+	// ExpectBodyRegex is a regular expression the body must match
+	ExpectBodyRegex *regexp.Regexp
+
+	// MaxResponseSize is how many bytes of the body are read at most, larger bodies fail the check
+	MaxResponseSize int64
+
+	client *http.Client
+}
+
+// NewHTTPHealthChecker creates a checker for the path, probing over https when tlsConfig is not nil
+func NewHTTPHealthChecker(path string, tlsConfig *tls.Config) *HTTPHealthChecker {
+	if path == "" {
+		path = "/health"
+	}
+
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+
+	transport := &http.Transport{
+		TLSClientConfig:     tlsConfig,
+		MaxIdleConnsPerHost: 1,
+		IdleConnTimeout:     90 * time.Second,
+	}
+
+	return &HTTPHealthChecker{
+		Path:            path,
+		Scheme:          scheme,
+		Method:          http.MethodGet,
+		MaxResponseSize: defaultMaxResponseSize,
+		client: &http.Client{
+			Transport: transport,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+func (c *HTTPHealthChecker) Check(ctx context.Context, svc *Service) error {
+	url := fmt.Sprintf("%s://%s%s", c.Scheme, checkAddress(svc, c.Port), c.Path)
+
+	req, err := http.NewRequestWithContext(ctx, c.Method, url, nil)
+	if err != nil {
+		return err
+	}
+
+	for name, value := range c.Headers {
+		req.Header.Set(name, value)
+	}
+
+	if c.Host != "" {
+		req.Host = c.Host
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if !c.acceptsStatus(resp.StatusCode) {
+		return fmt.Errorf("unexpected health check status %d", resp.StatusCode)
+	}
+
+	// Read one byte past the limit to tell a body of exactly the limit from a larger one
+	body, err := io.ReadAll(io.LimitReader(resp.Body, c.MaxResponseSize+1))
+	if err != nil {
+		return fmt.Errorf("failed to read health check response: %w", err)
+	}
+
+	if int64(len(body)) > c.MaxResponseSize {
+		return fmt.Errorf("health check response exceeds %d bytes", c.MaxResponseSize)
+	}
+
+	if c.ExpectBody != "" && !strings.Contains(string(body), c.ExpectBody) {
+		return fmt.Errorf("health check response doesn't contain %q", c.ExpectBody)
+	}
+
+	if c.ExpectBodyRegex != nil && !c.ExpectBodyRegex.Match(body) {
+		return fmt.Errorf("health check response doesn't match %s", c.ExpectBodyRegex)
+	}
+
+	return nil
+}
+
+// acceptsStatus reports whether the status code is one of the expected ones
+func (c *HTTPHealthChecker) acceptsStatus(status int) bool {
+	if len(c.ExpectStatus) == 0 {
+		return status == http.StatusOK
+	}
+
+	for _, expected := range c.ExpectStatus {
+		if expected.Contains(status) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ExecHealthChecker considers a service healthy if the command exits with a status of 0, the service is passed
+// to the command through the SPLITBIT_SERVICE_NAME, SPLITBIT_SERVICE_HOST and SPLITBIT_SERVICE_PORT variables
+type ExecHealthChecker struct {
+	Command []string
+}
+
+func (c *ExecHealthChecker) Check(ctx context.Context, svc *Service) error {
+	cmd := exec.CommandContext(ctx, c.Command[0], c.Command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"SPLITBIT_SERVICE_NAME="+svc.Name,
+		"SPLITBIT_SERVICE_HOST="+svc.Host,
+		"SPLITBIT_SERVICE_PORT="+strconv.Itoa(svc.Port),
+	)
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("health check command failed: %w: %s", err, output)
+	}
+
+	return nil
+}
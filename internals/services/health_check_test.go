@@ -0,0 +1,351 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/frostzt/splitbit/internals"
+)
+
+// newTestServiceFor creates a service pointing at the address of a test server
+func newTestServiceFor(t *testing.T, address string) *Service {
+	t.Helper()
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := newTestService("checked", StatePending, 1)
+	service.Host = host
+	service.Port, _ = strconv.Atoi(port)
+
+	return service
+}
+
+// newTestProtocolServer serves every connection with handle and returns a service pointing at it
+func newTestProtocolServer(t *testing.T, handle func(conn net.Conn)) *Service {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer func() { _ = conn.Close() }()
+				handle(conn)
+			}()
+		}
+	}()
+
+	return newTestServiceFor(t, listener.Addr().String())
+}
+
+func checkTestService(checker HealthChecker, service *Service) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	return checker.Check(ctx, service)
+}
+
+func TestTCPHealthChecker(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := newTestServiceFor(t, listener.Addr().String())
+	if err := checkTestService(&TCPHealthChecker{}, service); err != nil {
+		t.Errorf("expected the check to pass, got %v", err)
+	}
+
+	_ = listener.Close()
+	if err := checkTestService(&TCPHealthChecker{}, service); err == nil {
+		t.Error("expected the check to fail once the listener is closed")
+	}
+}
+
+func TestHTTPHealthChecker(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ready" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	service := newTestServiceFor(t, server.Listener.Addr().String())
+	if err := checkTestService(NewHTTPHealthChecker("/ready", nil), service); err != nil {
+		t.Errorf("expected the check to pass, got %v", err)
+	}
+
+	if err := checkTestService(NewHTTPHealthChecker("/health", nil), service); err == nil {
+		t.Error("expected the check to fail on a 503")
+	}
+}
+
+func TestHealthCheckPathWithoutChecker(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ready" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	service := newTestServiceFor(t, server.Listener.Addr().String())
+	service.HealthCheckPath = "/ready"
+	if err := service.HealthCheckService(context.Background()); err != nil {
+		t.Errorf("expected the deprecated path to be checked over HTTP, got %v", err)
+	}
+
+	options := &ServiceOptions{HealthCheckPath: "/health"}
+	if checker, ok := NewService("localhost", 9990, options, service.Logger).HealthChecker.(*HTTPHealthChecker); !ok || checker.Path != "/health" {
+		t.Errorf("expected an HTTP health check on the deprecated path, got %+v", checker)
+	}
+}
+
+func TestHTTPHealthCheckerExpectations(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Host != "health.internal" || r.Header.Get("X-Probe") != "splitbit" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/empty":
+			w.WriteHeader(http.StatusNoContent)
+		case "/large":
+			_, _ = w.Write(make([]byte, 2048))
+		default:
+			_, _ = w.Write([]byte(`{"status": "ok"}`))
+		}
+	}))
+	defer server.Close()
+
+	newChecker := func(path string) *HTTPHealthChecker {
+		checker := NewHTTPHealthChecker(path, nil)
+		checker.Method = http.MethodPost
+		checker.Host = "health.internal"
+		checker.Headers = map[string]string{"X-Probe": "splitbit"}
+		checker.MaxResponseSize = 1024
+
+		return checker
+	}
+
+	service := newTestServiceFor(t, server.Listener.Addr().String())
+
+	empty := newChecker("/empty")
+	if err := checkTestService(empty, service); err == nil {
+		t.Error("expected a 204 to fail when only a 200 is expected")
+	}
+
+	empty.ExpectStatus = []internals.StatusRange{{Min: 200, Max: 299}}
+	if err := checkTestService(empty, service); err != nil {
+		t.Errorf("expected a 204 to pass within 200-299, got %v", err)
+	}
+
+	body := newChecker("/status")
+	body.ExpectBody = `"status"`
+	body.ExpectBodyRegex = regexp.MustCompile(`"status":\s*"ok"`)
+	if err := checkTestService(body, service); err != nil {
+		t.Errorf("expected the body to match, got %v", err)
+	}
+
+	body.ExpectBodyRegex = regexp.MustCompile(`"status":\s*"degraded"`)
+	if err := checkTestService(body, service); err == nil {
+		t.Error("expected the check to fail when the body doesn't match")
+	}
+
+	if err := checkTestService(newChecker("/large"), service); err == nil {
+		t.Error("expected the check to fail when the body exceeds the max response size")
+	}
+}
+
+// writeTestPEM writes a PEM block into a file of the test directory and returns its path
+func writeTestPEM(t *testing.T, name string, blockType string, data []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// newTestClientCertificate creates a self-signed client certificate and returns it with the paths of its
+// certificate and key files
+func newTestClientCertificate(t *testing.T) (*x509.Certificate, string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "splitbit"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return certificate, writeTestPEM(t, "client.pem", "CERTIFICATE", der), writeTestPEM(t, "client-key.pem", "EC PRIVATE KEY", keyDER)
+}
+
+func TestHTTPSHealthChecker(t *testing.T) {
+	clientCertificate, certFile, keyFile := newTestClientCertificate(t)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCertificate)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	caFile := writeTestPEM(t, "ca.pem", "CERTIFICATE", server.Certificate().Raw)
+	service := newTestServiceFor(t, server.Listener.Addr().String())
+
+	tests := []struct {
+		name    string
+		tls     internals.HealthCheckTLSConfig
+		healthy bool
+	}{
+		{"with mTLS", internals.HealthCheckTLSConfig{ServerName: "example.com", CAFile: caFile, CertFile: certFile, KeyFile: keyFile}, true},
+		{"without a client certificate", internals.HealthCheckTLSConfig{ServerName: "example.com", CAFile: caFile}, false},
+		{"with an untrusted server", internals.HealthCheckTLSConfig{CertFile: certFile, KeyFile: keyFile}, false},
+		{"skipping verification", internals.HealthCheckTLSConfig{InsecureSkipVerify: true, CertFile: certFile, KeyFile: keyFile}, true},
+	}
+
+	for _, test := range tests {
+		checker, err := NewHealthChecker(internals.HealthCheckConfig{Type: HealthCheckHTTP, Path: "/", TLS: test.tls})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = checkTestService(checker, service)
+		if test.healthy && err != nil {
+			t.Errorf("%s: expected the check to pass, got %v", test.name, err)
+		} else if !test.healthy && err == nil {
+			t.Errorf("%s: expected the check to fail", test.name)
+		}
+	}
+
+	if _, err := NewHealthChecker(internals.HealthCheckConfig{Type: HealthCheckHTTP, Scheme: "https", TLS: internals.HealthCheckTLSConfig{CAFile: keyFile}}); err == nil {
+		t.Error("expected an error for a CA bundle without certificates")
+	}
+}
+
+func TestHealthCheckerPort(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = listener.Close() }()
+
+	// The service itself listens on a port nothing is bound to
+	service := newTestService("checked", StatePending, 1)
+	service.Host = "127.0.0.1"
+	service.Port = 1
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	checkPort, _ := strconv.Atoi(port)
+
+	if err := checkTestService(&TCPHealthChecker{Port: checkPort}, service); err != nil {
+		t.Errorf("expected the check to use its own port, got %v", err)
+	}
+}
+
+func TestExecHealthChecker(t *testing.T) {
+	service := newTestService("checked", StatePending, 1)
+	service.Port = 6379
+
+	passing := &ExecHealthChecker{Command: []string{"sh", "-c", `test "$SPLITBIT_SERVICE_PORT" = 6379`}}
+	if err := checkTestService(passing, service); err != nil {
+		t.Errorf("expected the check to pass, got %v", err)
+	}
+
+	failing := &ExecHealthChecker{Command: []string{"sh", "-c", "exit 1"}}
+	if err := checkTestService(failing, service); err == nil {
+		t.Error("expected the check to fail on a non-zero exit status")
+	}
+}
+
+func TestNewHealthChecker(t *testing.T) {
+	tests := []struct {
+		config   internals.HealthCheckConfig
+		expected HealthChecker
+	}{
+		{internals.HealthCheckConfig{}, &TCPHealthChecker{}},
+		{internals.HealthCheckConfig{Type: HealthCheckHTTP, Path: "/health"}, &HTTPHealthChecker{}},
+		{internals.HealthCheckConfig{Type: HealthCheckGRPC, GRPCService: "orders"}, &GRPCHealthChecker{}},
+		{internals.HealthCheckConfig{Type: HealthCheckRedis, Role: "master"}, &RedisHealthChecker{}},
+		{internals.HealthCheckConfig{Type: HealthCheckPostgres, Username: "splitbit"}, &PostgresHealthChecker{}},
+		{internals.HealthCheckConfig{Type: HealthCheckMySQL, Username: "splitbit"}, &MySQLHealthChecker{}},
+		{internals.HealthCheckConfig{Type: HealthCheckExec, Command: []string{"true"}}, &ExecHealthChecker{}},
+	}
+
+	for _, test := range tests {
+		checker, err := NewHealthChecker(test.config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, expected := fmt.Sprintf("%T", checker), fmt.Sprintf("%T", test.expected); got != expected {
+			t.Errorf("expected a %s for %+v, got %s", expected, test.config, got)
+		}
+	}
+
+	if _, err := NewHealthChecker(internals.HealthCheckConfig{Type: HealthCheckPostgres}); err == nil {
+		t.Error("expected an error for a postgres health check without a username")
+	}
+
+	if _, err := NewHealthChecker(internals.HealthCheckConfig{Type: "smoke-signal"}); err == nil {
+		t.Error("expected an error for an unsupported health check type")
+	}
+}
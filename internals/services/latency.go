@@ -0,0 +1,85 @@
+package services
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// defaultLatencyDecay is the time constant of the latency moving averages, an observation loses about
+// two thirds of its influence after this long
+const defaultLatencyDecay = 10 * time.Second
+
+// peakEWMA is an exponentially weighted moving average which jumps straight to any observation above it
+// and decays towards lower observations, so a latency spike is reflected immediately while a recovery is
+// only trusted over time. Without observations the average decays towards zero so that a slow service
+// which stopped receiving traffic is eventually tried again
+type peakEWMA struct {
+	value    float64
+	stamp    time.Time
+	observed bool
+	mu       sync.Mutex
+}
+
+// observe records a new latency observation made at now
+func (e *peakEWMA) observe(latency time.Duration, now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	sample := float64(latency)
+	if !e.observed {
+		e.value, e.stamp, e.observed = sample, now, true
+		return
+	}
+
+	if sample > e.value {
+		e.value = sample
+	} else {
+		weight := decayWeight(now.Sub(e.stamp))
+		e.value = e.value*weight + sample*(1-weight)
+	}
+
+	e.stamp = now
+}
+
+// estimate returns the average at now, ok is false if nothing was ever observed
+func (e *peakEWMA) estimate(now time.Time) (time.Duration, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.observed {
+		return 0, false
+	}
+
+	return time.Duration(e.value * decayWeight(now.Sub(e.stamp))), true
+}
+
+// decayWeight returns how much of the average is retained after elapsed
+func decayWeight(elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 1
+	}
+
+	return math.Exp(-float64(elapsed) / float64(defaultLatencyDecay))
+}
+
+// ObserveConnectLatency records how long it took to establish a connection to this service
+func (s *Service) ObserveConnectLatency(latency time.Duration) {
+	s.connectLatency.observe(latency, time.Now())
+}
+
+// ObserveFirstByteLatency records how long this service took to send its first byte on a connection
+func (s *Service) ObserveFirstByteLatency(latency time.Duration) {
+	s.firstByteLatency.observe(latency, time.Now())
+}
+
+// LatencyEstimate returns the sum of the connect and time-to-first-byte averages, ok is false if no
+// connection was ever made to this service
+func (s *Service) LatencyEstimate() (time.Duration, bool) {
+	now := time.Now()
+
+	connect, connectOk := s.connectLatency.estimate(now)
+	firstByte, _ := s.firstByteLatency.estimate(now)
+
+	return connect + firstByte, connectOk
+}
@@ -0,0 +1,95 @@
+package services
+
+import "sync"
+
+// LeastConnectionsSelector selects the alive service with the fewest active connections, ties are
+// broken by rotating the starting point so idle services share the load evenly
+type LeastConnectionsSelector struct {
+	services []*Service
+	index    int
+	mu       sync.Mutex
+}
+
+func NewLeastConnectionsSelector(services []*Service) *LeastConnectionsSelector {
+	return &LeastConnectionsSelector{
+		services: services,
+	}
+}
+
+func (lc *LeastConnectionsSelector) SelectService() *Service {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	if len(lc.services) == 0 {
+		return nil
+	}
+
+	var selected *Service
+	var selectedCount int64
+
+	start := lc.index % len(lc.services)
+	for i := 0; i < len(lc.services); i++ {
+		svc := lc.services[(start+i)%len(lc.services)]
+		if !svc.Available() {
+			continue
+		}
+
+		count := svc.ActiveConnections()
+		if selected == nil || count < selectedCount {
+			selected = svc
+			selectedCount = count
+		}
+	}
+
+	lc.index++
+	return selected
+}
+
+// WeightedLeastConnectionsSelector selects the alive service with the lowest ratio of active connections
+// to weight, so a service with twice the weight is expected to hold twice as many connections
+type WeightedLeastConnectionsSelector struct {
+	services []*Service
+	index    int
+	mu       sync.Mutex
+}
+
+func NewWeightedLeastConnectionsSelector(services []*Service) *WeightedLeastConnectionsSelector {
+	return &WeightedLeastConnectionsSelector{
+		services: services,
+	}
+}
+
+func (wlc *WeightedLeastConnectionsSelector) SelectService() *Service {
+	wlc.mu.Lock()
+	defer wlc.mu.Unlock()
+
+	if len(wlc.services) == 0 {
+		return nil
+	}
+
+	var selected *Service
+	var selectedScore float64
+
+	start := wlc.index % len(wlc.services)
+	for i := 0; i < len(wlc.services); i++ {
+		svc := wlc.services[(start+i)%len(wlc.services)]
+		if !svc.Available() {
+			continue
+		}
+
+		weight := svc.EffectiveWeight()
+		if weight <= 0 {
+			continue
+		}
+
+		// Count the connection about to be made so idle services are ordered by weight
+		score := float64(svc.ActiveConnections()+1) / weight
+		if selected == nil || score < selectedScore {
+			selected = svc
+			selectedScore = score
+		}
+	}
+
+	wlc.index++
+	return selected
+}
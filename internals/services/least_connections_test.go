@@ -0,0 +1,78 @@
+package services
+
+import "testing"
+
+func TestLeastConnectionsSelector(t *testing.T) {
+	services := newTestServices(3)
+	services[0].ConnectionCount = 5
+	services[1].ConnectionCount = 2
+	services[2].ConnectionCount = 7
+
+	selector := NewLeastConnectionsSelector(services)
+
+	if service := selector.SelectService(); service != services[1] {
+		t.Errorf("expected %s to be selected, got %v", services[1].Name, service)
+	}
+
+	services[1].FSM.CurrentState = StateDown
+	if service := selector.SelectService(); service != services[0] {
+		t.Errorf("expected %s to be selected, got %v", services[0].Name, service)
+	}
+}
+
+func TestLeastConnectionsSelectorSpreadsIdleServices(t *testing.T) {
+	services := newTestServices(3)
+	selector := NewLeastConnectionsSelector(services)
+
+	// Every selected service keeps its connection open, so picks must spread evenly
+	for i := 0; i < 9; i++ {
+		service := selector.SelectService()
+		if service == nil {
+			t.Fatal("service is nil")
+		}
+
+		service.AcquireConnection()
+	}
+
+	for _, service := range services {
+		if service.ActiveConnections() != 3 {
+			t.Errorf("expected 3 connections for %s, got %d", service.Name, service.ActiveConnections())
+		}
+	}
+}
+
+func TestWeightedLeastConnectionsSelector(t *testing.T) {
+	services := newTestServices(2)
+	services[0].Weight = 3
+	services[1].Weight = 1
+
+	selector := NewWeightedLeastConnectionsSelector(services)
+	for i := 0; i < 8; i++ {
+		service := selector.SelectService()
+		if service == nil {
+			t.Fatal("service is nil")
+		}
+
+		service.AcquireConnection()
+	}
+
+	if services[0].ActiveConnections() != 6 || services[1].ActiveConnections() != 2 {
+		t.Errorf("expected connections to follow a 3:1 ratio, got %d:%d",
+			services[0].ActiveConnections(), services[1].ActiveConnections())
+	}
+}
+
+func TestLeastConnectionsSelectorWithoutAliveServices(t *testing.T) {
+	services := newTestServices(2)
+	for _, service := range services {
+		service.FSM.CurrentState = StateDown
+	}
+
+	if service := NewLeastConnectionsSelector(services).SelectService(); service != nil {
+		t.Errorf("expected no service to be selected, got %s", service.Name)
+	}
+
+	if service := NewWeightedLeastConnectionsSelector(services).SelectService(); service != nil {
+		t.Errorf("expected no service to be selected, got %s", service.Name)
+	}
+}
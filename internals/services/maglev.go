@@ -0,0 +1,152 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/frostzt/splitbit/internals"
+)
+
+// defaultMaglevTableSize is the default size of the lookup table, it must be a prime and should be much larger
+// than the number of services so every service owns close to the same number of entries
+const defaultMaglevTableSize = 65537
+
+// MaglevSelector implements Maglev hashing, a lookup table is populated by letting every alive service claim
+// entries following its own permutation of the table, which gives every service an almost equal share of the
+// table and moves few entries when a service joins or leaves. The table is only rebuilt when a service enters
+// or leaves the ALIVE state or becomes available or unavailable. Like round-robin it ignores weights, so slow
+// start and weights reported by agents don't apply to it while agents draining a service still do
+type MaglevSelector struct {
+	services  []*Service
+	key       HashKey
+	tableSize uint64
+	table     []*Service
+	stale     atomic.Bool
+	mu        sync.RWMutex
+}
+
+func NewMaglevSelector(services []*Service, key HashKey, tableSize int) (*MaglevSelector, error) {
+	if !isPrime(tableSize) {
+		return nil, errors.New("table_size must be a prime number")
+	}
+
+	ms := &MaglevSelector{
+		services:  services,
+		key:       key,
+		tableSize: uint64(tableSize),
+	}
+
+	for _, svc := range services {
+		svc.OnStateChange(ms.onStateChange)
+	}
+
+	ms.rebuild()
+	return ms, nil
+}
+
+func (ms *MaglevSelector) SelectService(req *SelectionRequest) *Service {
+	if ms.stale.Swap(false) {
+		ms.rebuild()
+	}
+
+	svc := ms.lookup(req)
+	if svc != nil && !svc.Available() {
+		// The service became unavailable without its listeners being notified, rebuild right away
+		ms.rebuild()
+
+		svc = ms.lookup(req)
+		if svc != nil && !svc.Available() {
+			return nil
+		}
+	}
+
+	return svc
+}
+
+// lookup returns the service owning the table entry of the request
+func (ms *MaglevSelector) lookup(req *SelectionRequest) *Service {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	if len(ms.table) == 0 {
+		return nil
+	}
+
+	return ms.table[hashKey(req.Key(ms.key))%ms.tableSize]
+}
+
+// onStateChange marks the table as stale when the set of alive services changed
+func (ms *MaglevSelector) onStateChange(_ *Service, from internals.StateType, to internals.StateType) {
+	if from == StateAlive || to == StateAlive {
+		ms.stale.Store(true)
+	}
+}
+
+// rebuild populates the lookup table from the services which are currently available
+func (ms *MaglevSelector) rebuild() {
+	alive := make([]*Service, 0, len(ms.services))
+	for _, svc := range ms.services {
+		if svc.Available() {
+			alive = append(alive, svc)
+		}
+	}
+
+	table := populateMaglevTable(alive, ms.tableSize)
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.table = table
+}
+
+// populateMaglevTable builds the lookup table, every service takes turns claiming the next free entry of its
+// permutation until the table is full
+func populateMaglevTable(services []*Service, size uint64) []*Service {
+	if len(services) == 0 {
+		return nil
+	}
+
+	offsets := make([]uint64, len(services))
+	skips := make([]uint64, len(services))
+	next := make([]uint64, len(services))
+	for i, svc := range services {
+		offsets[i] = hashKey("offset-"+svc.Address()) % size
+		skips[i] = hashKey("skip-"+svc.Address())%(size-1) + 1
+	}
+
+	table := make([]*Service, size)
+	filled := uint64(0)
+	for {
+		for i := range services {
+			entry := (offsets[i] + next[i]*skips[i]) % size
+			for table[entry] != nil {
+				next[i]++
+				entry = (offsets[i] + next[i]*skips[i]) % size
+			}
+
+			table[entry] = services[i]
+			next[i]++
+			filled++
+
+			if filled == size {
+				return table
+			}
+		}
+	}
+}
+
+// isPrime reports whether n is a prime number
+func isPrime(n int) bool {
+	if n < 2 {
+		return false
+	}
+
+	for i := 2; i*i <= n; i++ {
+		if n%i == 0 {
+			return false
+		}
+	}
+
+	return true
+}
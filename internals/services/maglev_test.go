@@ -0,0 +1,107 @@
+package services
+
+import (
+	"net"
+	"testing"
+)
+
+func newTestRequest(i int) *SelectionRequest {
+	return &SelectionRequest{
+		ClientAddr: &net.TCPAddr{IP: net.IPv4(10, 0, byte(i/256), byte(i%256)), Port: 40000 + i},
+		LocalAddr:  &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000},
+	}
+}
+
+func TestMaglevSelectorBalancesTable(t *testing.T) {
+	services := newTestServices(7)
+	selector, err := NewMaglevSelector(services, HashKeySourceIP, 65537)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := map[*Service]int{}
+	for _, svc := range selector.table {
+		counts[svc]++
+	}
+
+	for _, svc := range services {
+		share := float64(counts[svc]) / float64(len(selector.table))
+		if share < 0.95/7 || share > 1.05/7 {
+			t.Errorf("expected %s to own about 1/7th of the table, owns %.4f", svc.Name, share)
+		}
+	}
+}
+
+func TestMaglevSelectorMinimalDisruption(t *testing.T) {
+	services := newTestServices(5)
+	selector, err := NewMaglevSelector(services, HashKeySourceIP, 65537)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := make([]*Service, 2000)
+	for i := range before {
+		before[i] = selector.SelectService(newTestRequest(i))
+	}
+
+	down := services[1]
+	sendTestEvent(t, down, EventFailure)
+
+	moved := 0
+	for i, previous := range before {
+		current := selector.SelectService(newTestRequest(i))
+		if current == down {
+			t.Fatalf("request %d was sent to a service which is down", i)
+		}
+
+		if previous != down && current != previous {
+			moved++
+		}
+	}
+
+	// Maglev trades a little disruption for balance, but almost every unaffected key must stay put
+	if moved > len(before)/20 {
+		t.Errorf("expected few unaffected keys to move, %d of %d moved", moved, len(before))
+	}
+}
+
+func TestMaglevSelectorRebuildsOnStateChange(t *testing.T) {
+	services := newTestServices(2)
+	for _, svc := range services {
+		svc.FSM.CurrentState = StatePending
+	}
+
+	selector, err := NewMaglevSelector(services, HashKeyFiveTuple, 13)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if svc := selector.SelectService(newTestRequest(0)); svc != nil {
+		t.Fatalf("expected no service before any is alive, got %s", svc.Name)
+	}
+
+	sendTestEvent(t, services[0], EventSuccess)
+	if svc := selector.SelectService(newTestRequest(0)); svc != services[0] {
+		t.Fatalf("expected the table to be rebuilt once a service is alive, got %v", svc)
+	}
+
+	table := selector.table
+
+	// A transition which doesn't change the alive set leaves the table untouched
+	sendTestEvent(t, services[1], EventFailure)
+	for i := 0; i < 10; i++ {
+		if svc := selector.SelectService(newTestRequest(i)); svc != services[0] {
+			t.Fatalf("expected the only alive service to be selected, got %v", svc)
+		}
+	}
+
+	if &table[0] != &selector.table[0] {
+		t.Error("expected the table not to be rebuilt when the alive set didn't change")
+	}
+}
+
+func TestMaglevSelectorRequiresPrimeTableSize(t *testing.T) {
+	if _, err := NewMaglevSelector(newTestServices(2), HashKeySourceIP, 65536); err == nil {
+		t.Error("expected an error for a table size which isn't prime")
+	}
+}
@@ -0,0 +1,392 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Capability flags of the MySQL client/server protocol used by the checker
+const (
+	mysqlClientLongPassword     = 0x00000001
+	mysqlClientConnectWithDB    = 0x00000008
+	mysqlClientProtocol41       = 0x00000200
+	mysqlClientSecureConnection = 0x00008000
+	mysqlClientPluginAuth       = 0x00080000
+)
+
+// Authentication plugins supported by the checker
+const (
+	mysqlNativePassword = "mysql_native_password"
+	mysqlCachingSHA2    = "caching_sha2_password"
+)
+
+// Commands and packet headers of the MySQL protocol
+const (
+	mysqlComQuit = 0x01
+	mysqlComPing = 0x0e
+
+	mysqlPacketOK       = 0x00
+	mysqlPacketMoreData = 0x01
+	mysqlPacketAuthEOF  = 0xfe
+	mysqlPacketError    = 0xff
+)
+
+const (
+	// mysqlMaxPacketSize caps the size of the packets read during a check
+	mysqlMaxPacketSize = 1 << 20
+
+	// mysqlNonceLength is the length of the nonce both supported plugins scramble the password with
+	mysqlNonceLength = 20
+)
+
+// MySQLHealthChecker considers a service healthy if it can be logged into and answers a COM_PING. The
+// mysql_native_password and caching_sha2_password plugins are supported, a full caching_sha2_password
+// authentication encrypts the password with the RSA key of the server
+type MySQLHealthChecker struct {
+	// Port overrides the port of the service when set
+	Port int
+
+	Username string
+	Password string
+
+	// Database is optional, the check fails if the user can't access it when set
+	Database string
+}
+
+func (c *MySQLHealthChecker) Check(ctx context.Context, svc *Service) error {
+	conn, err := dialHealthCheck(ctx, svc, c.Port)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	my := &mysqlConn{conn: conn, reader: bufio.NewReader(conn)}
+	if err := my.login(c.Username, c.Password, c.Database); err != nil {
+		return err
+	}
+
+	my.sequence = 0
+	if err := my.send([]byte{mysqlComPing}); err != nil {
+		return err
+	}
+
+	if _, err := my.receiveOK(); err != nil {
+		return fmt.Errorf("mysql COM_PING failed: %w", err)
+	}
+
+	my.sequence = 0
+	return my.send([]byte{mysqlComQuit})
+}
+
+// mysqlConn speaks the client side of the MySQL protocol
+type mysqlConn struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	sequence byte
+}
+
+// login reads the handshake of the server and authenticates with it
+func (my *mysqlConn) login(username string, password string, database string) error {
+	packet, err := my.receive()
+	if err != nil {
+		return err
+	}
+
+	if len(packet) > 0 && packet[0] == mysqlPacketError {
+		return mysqlError(packet)
+	}
+
+	nonce, plugin, err := parseMySQLHandshake(packet)
+	if err != nil {
+		return err
+	}
+
+	authResponse, err := mysqlScramble(plugin, password, nonce)
+	if err != nil {
+		return err
+	}
+
+	capabilities := uint32(mysqlClientLongPassword | mysqlClientProtocol41 | mysqlClientSecureConnection | mysqlClientPluginAuth)
+	if database != "" {
+		capabilities |= mysqlClientConnectWithDB
+	}
+
+	var response []byte
+	response = binary.LittleEndian.AppendUint32(response, capabilities)
+	response = binary.LittleEndian.AppendUint32(response, mysqlMaxPacketSize)
+
+	// utf8mb4_general_ci followed by the reserved filler
+	response = append(response, 45)
+	response = append(response, make([]byte, 23)...)
+
+	response = append(response, username...)
+	response = append(response, 0, byte(len(authResponse)))
+	response = append(response, authResponse...)
+	if database != "" {
+		response = append(response, database...)
+		response = append(response, 0)
+	}
+	response = append(response, plugin...)
+	response = append(response, 0)
+
+	if err := my.send(response); err != nil {
+		return err
+	}
+
+	for {
+		packet, err := my.receiveOK()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case packet == nil:
+			return nil
+		case packet[0] == mysqlPacketAuthEOF:
+			// The server switches to another plugin and sends a new nonce along with it
+			name, data, _ := bytes.Cut(packet[1:], []byte{0})
+			plugin, nonce = string(name), bytes.TrimSuffix(data, []byte{0})
+			if len(nonce) < mysqlNonceLength {
+				return fmt.Errorf("mysql sent a nonce of %d bytes when switching to %s", len(nonce), plugin)
+			}
+
+			if authResponse, err = mysqlScramble(plugin, password, nonce); err != nil {
+				return err
+			}
+
+			if err := my.send(authResponse); err != nil {
+				return err
+			}
+		case packet[0] == mysqlPacketMoreData && plugin == mysqlCachingSHA2 && len(packet) == 2:
+			if err := my.continueCachingSHA2(packet[1], password, nonce); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unexpected mysql packet 0x%02x during authentication", packet[0])
+		}
+	}
+}
+
+// continueCachingSHA2 handles the status sent by caching_sha2_password, 3 means the scramble matched a
+// cached password and 4 that the password must be sent in full
+func (my *mysqlConn) continueCachingSHA2(status byte, password string, nonce []byte) error {
+	switch status {
+	case 3:
+		return nil
+	case 4:
+	default:
+		return fmt.Errorf("unexpected caching_sha2_password status %d", status)
+	}
+
+	// Without TLS the password is encrypted with the public key of the server, which is requested first
+	if err := my.send([]byte{2}); err != nil {
+		return err
+	}
+
+	packet, err := my.receive()
+	if err != nil {
+		return err
+	}
+
+	if len(packet) == 0 || packet[0] != mysqlPacketMoreData {
+		return errors.New("mysql didn't send its public key")
+	}
+
+	block, _ := pem.Decode(packet[1:])
+	if block == nil {
+		return errors.New("malformed mysql public key")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("malformed mysql public key: %w", err)
+	}
+
+	publicKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("mysql public key is not an RSA key")
+	}
+
+	plain := append([]byte(password), 0)
+	for i := range plain {
+		plain[i] ^= nonce[i%len(nonce)]
+	}
+
+	encrypted, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, publicKey, plain, nil)
+	if err != nil {
+		return err
+	}
+
+	return my.send(encrypted)
+}
+
+// send writes a packet with the next sequence number
+func (my *mysqlConn) send(payload []byte) error {
+	header := binary.LittleEndian.AppendUint32(nil, uint32(len(payload)))
+	header[3] = my.sequence
+	my.sequence++
+
+	_, err := my.conn.Write(append(header, payload...))
+	return err
+}
+
+// receive reads a packet and moves the sequence number past it
+func (my *mysqlConn) receive() ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(my.reader, header[:]); err != nil {
+		return nil, err
+	}
+
+	length := uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16
+	if length > mysqlMaxPacketSize {
+		return nil, fmt.Errorf("invalid mysql packet length %d", length)
+	}
+
+	my.sequence = header[3] + 1
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(my.reader, payload); err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+// receiveOK reads a packet, returning nil for an OK packet, an error for an error packet and the packet
+// itself otherwise
+func (my *mysqlConn) receiveOK() ([]byte, error) {
+	packet, err := my.receive()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(packet) == 0 {
+		return nil, errors.New("empty mysql packet")
+	}
+
+	switch packet[0] {
+	case mysqlPacketOK:
+		return nil, nil
+	case mysqlPacketError:
+		return nil, mysqlError(packet)
+	}
+
+	return packet, nil
+}
+
+// parseMySQLHandshake returns the nonce and authentication plugin of a protocol 10 handshake
+func parseMySQLHandshake(packet []byte) ([]byte, string, error) {
+	malformed := errors.New("malformed mysql handshake")
+	if len(packet) == 0 || packet[0] != 10 {
+		return nil, "", errors.New("unsupported mysql protocol version")
+	}
+
+	// The server version is followed by the connection id
+	end := bytes.IndexByte(packet[1:], 0)
+	if end < 0 {
+		return nil, "", malformed
+	}
+	rest := packet[1+end+1:]
+
+	// First part of the nonce, a filler and the lower capabilities
+	if len(rest) < 4+8+1+2 {
+		return nil, "", malformed
+	}
+	nonce := append([]byte(nil), rest[4:12]...)
+	rest = rest[15:]
+
+	// Old servers stop after the lower capabilities and only have the first part of the nonce
+	if len(rest) < 1+2+2+1+10 {
+		return nil, "", errors.New("mysql server predates protocol 4.1 authentication")
+	}
+
+	nonceLength := int(rest[5])
+	rest = rest[16:]
+
+	secondPart := max(13, nonceLength-8)
+	if len(rest) < secondPart {
+		return nil, "", malformed
+	}
+
+	// The second part of the nonce is terminated by a NUL which is not part of it
+	nonce = append(nonce, bytes.TrimSuffix(rest[:secondPart], []byte{0})...)
+	rest = rest[secondPart:]
+
+	if len(nonce) < mysqlNonceLength {
+		return nil, "", malformed
+	}
+
+	plugin, _, _ := bytes.Cut(rest, []byte{0})
+	if len(plugin) == 0 {
+		return nonce, mysqlNativePassword, nil
+	}
+
+	return nonce, string(plugin), nil
+}
+
+// mysqlScramble returns the authentication response of the plugin for the password and nonce
+func mysqlScramble(plugin string, password string, nonce []byte) ([]byte, error) {
+	if password == "" {
+		if plugin != mysqlNativePassword && plugin != mysqlCachingSHA2 {
+			return nil, fmt.Errorf("unsupported mysql authentication plugin %s", plugin)
+		}
+
+		return nil, nil
+	}
+
+	switch plugin {
+	case mysqlNativePassword:
+		// SHA1(password) XOR SHA1(nonce + SHA1(SHA1(password)))
+		hashed := sha1.Sum([]byte(password))
+		doubleHashed := sha1.Sum(hashed[:])
+		scramble := sha1.Sum(append(append([]byte(nil), nonce...), doubleHashed[:]...))
+
+		for i := range scramble {
+			scramble[i] ^= hashed[i]
+		}
+
+		return scramble[:], nil
+	case mysqlCachingSHA2:
+		// SHA256(password) XOR SHA256(SHA256(SHA256(password)) + nonce)
+		hashed := sha256.Sum256([]byte(password))
+		doubleHashed := sha256.Sum256(hashed[:])
+		scramble := sha256.Sum256(append(doubleHashed[:], nonce...))
+
+		for i := range scramble {
+			scramble[i] ^= hashed[i]
+		}
+
+		return scramble[:], nil
+	default:
+		return nil, fmt.Errorf("unsupported mysql authentication plugin %s", plugin)
+	}
+}
+
+// mysqlError turns an error packet into an error
+func mysqlError(packet []byte) error {
+	if len(packet) < 3 {
+		return errors.New("malformed mysql error")
+	}
+
+	code := binary.LittleEndian.Uint16(packet[1:3])
+	message := packet[3:]
+
+	// Protocol 4.1 errors carry a SQL state marked by a #
+	if len(message) >= 6 && message[0] == '#' {
+		message = message[6:]
+	}
+
+	return fmt.Errorf("mysql error %d: %s", code, message)
+}
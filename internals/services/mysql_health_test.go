@@ -0,0 +1,210 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"testing"
+)
+
+// testMySQLServer logs clients in and answers COM_PING
+type testMySQLServer struct {
+	plugin   string
+	username string
+	password string
+
+	// switchTo makes the server switch the client to another plugin with switchNonce
+	switchTo    string
+	switchNonce []byte
+
+	// key makes caching_sha2_password logins take the full path as if the password wasn't cached, the
+	// client has to send the password encrypted with it. Logins take the fast path without a key
+	key *rsa.PrivateKey
+}
+
+func (s *testMySQLServer) serve(conn net.Conn) {
+	my := &mysqlConn{conn: conn, reader: bufio.NewReader(conn)}
+	plugin, nonce := s.plugin, []byte("abcdefghijklmnopqrst")
+
+	var handshake []byte
+	handshake = append(handshake, 10)
+	handshake = append(handshake, "8.4.0-test\x00"...)
+	handshake = append(handshake, 1, 0, 0, 0)
+	handshake = append(handshake, nonce[:8]...)
+	handshake = append(handshake, 0, 0xff, 0xff, 45, 2, 0, 0xff, 0xff, byte(len(nonce)+1))
+	handshake = append(handshake, make([]byte, 10)...)
+	handshake = append(handshake, nonce[8:]...)
+	handshake = append(handshake, 0)
+	handshake = append(handshake, plugin...)
+	handshake = append(handshake, 0)
+
+	if err := my.send(handshake); err != nil {
+		return
+	}
+
+	response, err := my.receive()
+	if err != nil || len(response) < 32 {
+		return
+	}
+
+	// Skip the capabilities, max packet size, charset and filler
+	user, rest, _ := bytes.Cut(response[32:], []byte{0})
+	if len(rest) == 0 || len(rest) < 1+int(rest[0]) {
+		return
+	}
+	authResponse := rest[1 : 1+rest[0]]
+
+	if s.switchTo != "" {
+		plugin, nonce = s.switchTo, s.switchNonce
+
+		switchRequest := append([]byte{mysqlPacketAuthEOF}, plugin...)
+		switchRequest = append(switchRequest, 0)
+		switchRequest = append(switchRequest, nonce...)
+		if err := my.send(append(switchRequest, 0)); err != nil {
+			return
+		}
+
+		if authResponse, err = my.receive(); err != nil {
+			return
+		}
+	}
+
+	denied := append([]byte{mysqlPacketError, 0x15, 0x04}, "#28000Access denied"...)
+	expected, _ := mysqlScramble(plugin, s.password, nonce)
+
+	switch {
+	case string(user) != s.username:
+		_ = my.send(denied)
+		return
+	case plugin == mysqlCachingSHA2 && s.key != nil:
+		if !s.fullAuthentication(my, nonce) {
+			_ = my.send(denied)
+			return
+		}
+	case !bytes.Equal(authResponse, expected):
+		_ = my.send(denied)
+		return
+	case plugin == mysqlCachingSHA2:
+		_ = my.send([]byte{mysqlPacketMoreData, 3})
+	}
+
+	_ = my.send([]byte{mysqlPacketOK, 0, 0, 2, 0, 0, 0})
+
+	for {
+		my.sequence = 0
+		command, err := my.receive()
+		if err != nil || len(command) == 0 || command[0] != mysqlComPing {
+			return
+		}
+
+		_ = my.send([]byte{mysqlPacketOK, 0, 0, 2, 0, 0, 0})
+	}
+}
+
+// fullAuthentication sends the public key of the server and reports whether the client sent the password
+// encrypted with it
+func (s *testMySQLServer) fullAuthentication(my *mysqlConn, nonce []byte) bool {
+	if err := my.send([]byte{mysqlPacketMoreData, 4}); err != nil {
+		return false
+	}
+
+	request, err := my.receive()
+	if err != nil || !bytes.Equal(request, []byte{2}) {
+		return false
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&s.key.PublicKey)
+	if err != nil {
+		return false
+	}
+
+	publicKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	if err := my.send(append([]byte{mysqlPacketMoreData}, publicKey...)); err != nil {
+		return false
+	}
+
+	encrypted, err := my.receive()
+	if err != nil {
+		return false
+	}
+
+	plain, err := rsa.DecryptOAEP(sha1.New(), nil, s.key, encrypted, nil)
+	if err != nil {
+		return false
+	}
+
+	for i := range plain {
+		plain[i] ^= nonce[i%len(nonce)]
+	}
+
+	return string(plain) == s.password+"\x00"
+}
+
+func TestMySQLHealthChecker(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switchNonce := []byte("ABCDEFGHIJKLMNOPQRST")
+	native := newTestProtocolServer(t, (&testMySQLServer{plugin: mysqlNativePassword, username: "splitbit", password: "secret"}).serve)
+	cachingSHA2 := newTestProtocolServer(t, (&testMySQLServer{plugin: mysqlCachingSHA2, username: "splitbit", password: "secret"}).serve)
+	passwordless := newTestProtocolServer(t, (&testMySQLServer{plugin: mysqlNativePassword, username: "monitor"}).serve)
+	fullAuth := newTestProtocolServer(t, (&testMySQLServer{plugin: mysqlCachingSHA2, username: "splitbit", password: "secret", key: key}).serve)
+	switched := newTestProtocolServer(t, (&testMySQLServer{
+		plugin: mysqlCachingSHA2, username: "splitbit", password: "secret", switchTo: mysqlNativePassword, switchNonce: switchNonce,
+	}).serve)
+	switchedFullAuth := newTestProtocolServer(t, (&testMySQLServer{
+		plugin: mysqlNativePassword, username: "splitbit", password: "secret", switchTo: mysqlCachingSHA2, switchNonce: switchNonce, key: key,
+	}).serve)
+	emptyNonce := newTestProtocolServer(t, (&testMySQLServer{
+		plugin: mysqlNativePassword, username: "splitbit", password: "secret", switchTo: mysqlCachingSHA2, key: key,
+	}).serve)
+
+	tests := []struct {
+		name    string
+		service *Service
+		checker *MySQLHealthChecker
+		healthy bool
+	}{
+		{"with mysql_native_password", native, &MySQLHealthChecker{Username: "splitbit", Password: "secret"}, true},
+		{"with a wrong password", native, &MySQLHealthChecker{Username: "splitbit", Password: "guess"}, false},
+		{"with caching_sha2_password", cachingSHA2, &MySQLHealthChecker{Username: "splitbit", Password: "secret", Database: "app"}, true},
+		{"with a wrong user", cachingSHA2, &MySQLHealthChecker{Username: "root", Password: "secret"}, false},
+		{"without a password", passwordless, &MySQLHealthChecker{Username: "monitor"}, true},
+		{"with a full caching_sha2_password authentication", fullAuth, &MySQLHealthChecker{Username: "splitbit", Password: "secret"}, true},
+		{"with a wrong password sent in full", fullAuth, &MySQLHealthChecker{Username: "splitbit", Password: "guess"}, false},
+		{"after switching plugins", switched, &MySQLHealthChecker{Username: "splitbit", Password: "secret"}, true},
+		{"after switching to a full authentication", switchedFullAuth, &MySQLHealthChecker{Username: "splitbit", Password: "secret"}, true},
+		{"after switching without a nonce", emptyNonce, &MySQLHealthChecker{Username: "splitbit", Password: "secret"}, false},
+	}
+
+	for _, test := range tests {
+		err := checkTestService(test.checker, test.service)
+		if test.healthy && err != nil {
+			t.Errorf("%s: expected the check to pass, got %v", test.name, err)
+		} else if !test.healthy && err == nil {
+			t.Errorf("%s: expected the check to fail", test.name)
+		}
+	}
+}
+
+func TestParseMySQLHandshake(t *testing.T) {
+	if _, _, err := parseMySQLHandshake([]byte{9, 'x', 0}); err == nil {
+		t.Error("expected an error for an unsupported protocol version")
+	}
+
+	if _, _, err := parseMySQLHandshake([]byte{10, '8', 0, 1, 2}); err == nil {
+		t.Error("expected an error for a truncated handshake")
+	}
+
+	// Servers from before protocol 4.1 only send the first 8 bytes of the nonce
+	if _, _, err := parseMySQLHandshake(append([]byte{10, '5', 0, 1, 0, 0, 0}, "abcdefgh\x00\xff\xff"...)); err == nil {
+		t.Error("expected an error for a handshake with a short nonce")
+	}
+}
@@ -0,0 +1,183 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/frostzt/splitbit/internals"
+)
+
+// passiveHealthBuckets is the number of buckets the error rate window is split into
+const passiveHealthBuckets = 10
+
+// PassiveHealthOptions configures how outcomes of proxied connections eject a service
+type PassiveHealthOptions struct {
+	// ConsecutiveFailures is the number of dial failures in a row which eject the service
+	ConsecutiveFailures int
+
+	// ErrorRate is the fraction of failed connections within Window which ejects the service
+	ErrorRate float64
+
+	// Window is how far back connection outcomes are considered for the error rate
+	Window time.Duration
+
+	// MinRequests is the number of connections Window must hold before the error rate is trusted
+	MinRequests int
+}
+
+// outcomeBucket counts connection outcomes for a slice of the window
+type outcomeBucket struct {
+	start     time.Time
+	successes int
+	failures  int
+}
+
+// passiveHealth tracks the outcome of connections proxied to a service, the window is a ring of buckets
+// so old outcomes fall out of it without keeping every single one of them around
+type passiveHealth struct {
+	opts        PassiveHealthOptions
+	consecutive int
+	buckets     [passiveHealthBuckets]outcomeBucket
+	mu          sync.Mutex
+}
+
+// newPassiveHealth fills the options which were left empty with their defaults, a window shorter than a
+// second is widened to one
+func newPassiveHealth(opts PassiveHealthOptions) *passiveHealth {
+	if opts.ConsecutiveFailures <= 0 {
+		opts.ConsecutiveFailures = internals.DefaultPassiveConsecutiveFailures
+	}
+
+	if opts.ErrorRate <= 0 || opts.ErrorRate > 1 {
+		opts.ErrorRate = internals.DefaultPassiveErrorRate
+	}
+
+	if opts.Window <= 0 {
+		opts.Window = internals.DefaultPassiveWindow
+	} else if opts.Window < internals.MinPassiveWindow {
+		opts.Window = internals.MinPassiveWindow
+	}
+
+	if opts.MinRequests <= 0 {
+		opts.MinRequests = internals.DefaultPassiveMinRequests
+	}
+
+	return &passiveHealth{opts: opts}
+}
+
+// recordDial counts a dial attempt, it returns why the service should be ejected or an empty string
+func (p *passiveHealth) recordDial(err error, now time.Time) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err == nil {
+		p.consecutive = 0
+		return ""
+	}
+
+	p.consecutive++
+	p.count(true, now)
+
+	if p.consecutive >= p.opts.ConsecutiveFailures {
+		return fmt.Sprintf("%d consecutive dial failures", p.consecutive)
+	}
+
+	return p.checkErrorRate(now)
+}
+
+// recordConnection counts the outcome of a connection which was dialed successfully, it returns why the
+// service should be ejected or an empty string
+func (p *passiveHealth) recordConnection(err error, now time.Time) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.count(err != nil, now)
+	if err == nil {
+		return ""
+	}
+
+	return p.checkErrorRate(now)
+}
+
+// reset forgets every outcome, so a service coming back isn't ejected again for failures it already paid for
+func (p *passiveHealth) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.consecutive = 0
+	p.buckets = [passiveHealthBuckets]outcomeBucket{}
+}
+
+// count adds an outcome to the bucket of now, recycling the bucket if it belongs to an older window
+func (p *passiveHealth) count(failed bool, now time.Time) {
+	width := p.opts.Window / passiveHealthBuckets
+	start := now.Truncate(width)
+	bucket := &p.buckets[(start.UnixNano()/int64(width))%passiveHealthBuckets]
+
+	if !bucket.start.Equal(start) {
+		*bucket = outcomeBucket{start: start}
+	}
+
+	if failed {
+		bucket.failures++
+	} else {
+		bucket.successes++
+	}
+}
+
+// checkErrorRate returns why the service should be ejected if the error rate within the window is too high
+func (p *passiveHealth) checkErrorRate(now time.Time) string {
+	var successes, failures int
+	for _, bucket := range p.buckets {
+		if now.Sub(bucket.start) < p.opts.Window {
+			successes += bucket.successes
+			failures += bucket.failures
+		}
+	}
+
+	total := successes + failures
+	if total < p.opts.MinRequests || total == 0 {
+		return ""
+	}
+
+	rate := float64(failures) / float64(total)
+	if rate < p.opts.ErrorRate {
+		return ""
+	}
+
+	return fmt.Sprintf("error rate of %.0f%% over the last %s", rate*100, p.opts.Window)
+}
+
+// ReportDialResult feeds the result of dialing this service for a proxied connection into passive health checking
+func (s *Service) ReportDialResult(err error) {
+	if s.passiveHealth == nil {
+		return
+	}
+
+	s.ejectIf(s.passiveHealth.recordDial(err, time.Now()))
+}
+
+// ReportConnectionResult feeds the outcome of a proxied connection into passive health checking, err should
+// only be set for failures caused by the service such as resets or the service never responding
+func (s *Service) ReportConnectionResult(err error) {
+	if s.passiveHealth == nil {
+		return
+	}
+
+	s.ejectIf(s.passiveHealth.recordConnection(err, time.Now()))
+}
+
+// ejectIf takes the service DOWN for the provided reason, without waiting for active health checks to fail
+func (s *Service) ejectIf(reason string) {
+	if reason == "" || s.FSM.CurrentState != StateAlive {
+		return
+	}
+
+	s.Logger.Warn("Passive health check ejected service %s: %s", s.Name, reason)
+	s.passiveHealth.reset()
+
+	if err := s.FSM.SendEvent(EventFailure, &CommonActionCtx{svc: s}); err != nil {
+		s.Logger.Warn("FSM rejected event %s for service %s, %v", EventFailure, s.Name, err)
+	}
+}
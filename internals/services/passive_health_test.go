@@ -0,0 +1,104 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/frostzt/splitbit/internals"
+)
+
+var errTestConnection = errors.New("connection reset by peer")
+
+// newTestPassiveService creates an alive service with passive health checking enabled
+func newTestPassiveService(opts PassiveHealthOptions) *Service {
+	service := newTestService("passive", StateAlive, 1)
+	service.passiveHealth = &passiveHealth{opts: opts}
+
+	return service
+}
+
+func TestPassiveHealthEjectsAfterConsecutiveDialFailures(t *testing.T) {
+	service := newTestPassiveService(PassiveHealthOptions{ConsecutiveFailures: 3, ErrorRate: 1, Window: time.Minute, MinRequests: 100})
+
+	service.ReportDialResult(errTestConnection)
+	service.ReportDialResult(errTestConnection)
+	service.ReportDialResult(nil)
+	service.ReportDialResult(errTestConnection)
+	service.ReportDialResult(errTestConnection)
+
+	if service.FSM.CurrentState != StateAlive {
+		t.Fatalf("expected a successful dial to reset the failure streak, got %s", service.FSM.CurrentState)
+	}
+
+	service.ReportDialResult(errTestConnection)
+	if service.FSM.CurrentState != StateDown {
+		t.Errorf("expected the service to be ejected after 3 consecutive dial failures, got %s", service.FSM.CurrentState)
+	}
+}
+
+func TestPassiveHealthEjectsOnErrorRate(t *testing.T) {
+	service := newTestPassiveService(PassiveHealthOptions{ConsecutiveFailures: 100, ErrorRate: 0.5, Window: time.Minute, MinRequests: 10})
+
+	// Failures below MinRequests are not trusted, even though every single connection failed
+	for i := 0; i < 4; i++ {
+		service.ReportConnectionResult(errTestConnection)
+	}
+
+	if service.FSM.CurrentState != StateAlive {
+		t.Fatalf("expected the service to stay alive below the minimum number of requests, got %s", service.FSM.CurrentState)
+	}
+
+	for i := 0; i < 5; i++ {
+		service.ReportConnectionResult(nil)
+	}
+
+	service.ReportConnectionResult(errTestConnection)
+	if service.FSM.CurrentState != StateDown {
+		t.Errorf("expected the service to be ejected at an error rate of 50%%, got %s", service.FSM.CurrentState)
+	}
+}
+
+func TestPassiveHealthWindowForgetsOldOutcomes(t *testing.T) {
+	health := &passiveHealth{opts: PassiveHealthOptions{ConsecutiveFailures: 100, ErrorRate: 0.5, Window: 10 * time.Second, MinRequests: 4}}
+	now := time.Unix(1_700_000_000, 0)
+
+	for i := 0; i < 3; i++ {
+		if reason := health.recordConnection(errTestConnection, now); reason != "" {
+			t.Fatalf("expected no ejection below the minimum number of requests, got %q", reason)
+		}
+	}
+
+	// The failures are outside of the window by now, only the new outcomes count
+	later := now.Add(15 * time.Second)
+	for i := 0; i < 3; i++ {
+		health.recordConnection(nil, later)
+	}
+
+	if reason := health.recordConnection(errTestConnection, later); reason != "" {
+		t.Errorf("expected failures outside of the window to be forgotten, got %q", reason)
+	}
+
+	health.recordConnection(errTestConnection, later.Add(time.Second))
+	if reason := health.recordConnection(errTestConnection, later.Add(time.Second)); reason == "" {
+		t.Error("expected an ejection once half of the connections within the window failed")
+	}
+}
+
+func TestPassiveHealthDefaultsEmptyOptions(t *testing.T) {
+	service := NewService("localhost", 9990, &ServiceOptions{PassiveHealthCheck: &PassiveHealthOptions{}}, internals.NewLogger(internals.EnvProd))
+
+	expected := PassiveHealthOptions{ConsecutiveFailures: 5, ErrorRate: 0.5, Window: 30 * time.Second, MinRequests: 10}
+	if service.passiveHealth.opts != expected {
+		t.Errorf("expected the defaults %+v, got %+v", expected, service.passiveHealth.opts)
+	}
+
+	// A window too short to be split into buckets is widened instead of dividing by zero
+	health := newPassiveHealth(PassiveHealthOptions{Window: 5 * time.Nanosecond})
+	if health.opts.Window != time.Second {
+		t.Errorf("expected the window to be widened to 1s, got %s", health.opts.Window)
+	}
+
+	service.ReportConnectionResult(errTestConnection)
+	health.recordConnection(errTestConnection, time.Now())
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// defaultUnobservedLatency is the latency assumed for services which haven't been connected to yet, it keeps
+// a freshly registered service from winning every comparison before anything is known about it
+const defaultUnobservedLatency = 50 * time.Millisecond
+
+// PeakEWMASelector picks two random alive services and selects the one with the lowest cost, the cost of a
+// service being its peak EWMA latency multiplied by its active connections plus the one about to be made,
+// divided by its weight
+type PeakEWMASelector struct {
+	services          []*Service
+	unobservedLatency time.Duration
+	rng               *rand.Rand
+	mu                sync.Mutex
+}
+
+func NewPeakEWMASelector(services []*Service, unobservedLatency time.Duration, rng *rand.Rand) *PeakEWMASelector {
+	return &PeakEWMASelector{
+		services:          services,
+		unobservedLatency: unobservedLatency,
+		rng:               rng,
+	}
+}
+
+func (pe *PeakEWMASelector) SelectService() *Service {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+
+	first, second := pickTwoAlive(pe.services, pe.rng)
+	if second == nil {
+		return first
+	}
+
+	if pe.cost(second) < pe.cost(first) {
+		return second
+	}
+
+	return first
+}
+
+// cost returns the latency of the service weighted by the connections it is already handling and scaled
+// down by its weight
+func (pe *PeakEWMASelector) cost(svc *Service) float64 {
+	latency, ok := svc.LatencyEstimate()
+	if !ok {
+		latency = pe.unobservedLatency
+	}
+
+	return float64(latency) * float64(svc.ActiveConnections()+1) / svc.EffectiveWeight()
+}
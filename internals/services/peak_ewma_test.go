@@ -0,0 +1,79 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPeakEWMA(t *testing.T) {
+	var ewma peakEWMA
+	now := time.Now()
+
+	if _, ok := ewma.estimate(now); ok {
+		t.Fatal("expected no estimate before any observation")
+	}
+
+	ewma.observe(10*time.Millisecond, now)
+	ewma.observe(100*time.Millisecond, now.Add(time.Millisecond))
+
+	// Peaks are reflected immediately
+	if estimate, _ := ewma.estimate(now.Add(time.Millisecond)); estimate != 100*time.Millisecond {
+		t.Errorf("expected the estimate to jump to the peak, got %s", estimate)
+	}
+
+	// Lower observations only pull the average down over time
+	ewma.observe(10*time.Millisecond, now.Add(2*time.Millisecond))
+	if estimate, _ := ewma.estimate(now.Add(2 * time.Millisecond)); estimate < 90*time.Millisecond {
+		t.Errorf("expected the estimate to decay slowly, got %s", estimate)
+	}
+
+	ewma.observe(10*time.Millisecond, now.Add(time.Minute))
+	if estimate, _ := ewma.estimate(now.Add(time.Minute)); estimate > 15*time.Millisecond {
+		t.Errorf("expected the estimate to follow lower observations over time, got %s", estimate)
+	}
+}
+
+func TestPeakEWMASelectorPrefersFastServices(t *testing.T) {
+	services := newTestServices(2)
+	services[0].ObserveConnectLatency(time.Millisecond)
+	services[1].ObserveConnectLatency(50 * time.Millisecond)
+
+	selector := NewPeakEWMASelector(services, defaultUnobservedLatency, newSelectorRand(1))
+
+	// With two services both are always compared, so the fast one wins until it is loaded enough
+	for i := 0; i < 20; i++ {
+		service := selector.SelectService()
+		if service != services[0] {
+			t.Fatalf("expected the fast service to be selected at %d, got %s", i, service.Name)
+		}
+
+		service.AcquireConnection()
+	}
+
+	for i := 0; i < 100; i++ {
+		selector.SelectService().AcquireConnection()
+	}
+
+	if services[1].ActiveConnections() == 0 {
+		t.Error("expected the slow service to receive connections once the fast one is loaded")
+	}
+}
+
+func TestPeakEWMASelectorSkipsUnavailableServices(t *testing.T) {
+	services := newTestServices(3)
+	services[0].ObserveConnectLatency(time.Millisecond)
+	services[0].FSM.CurrentState = StateDown
+
+	selector := NewPeakEWMASelector(services, defaultUnobservedLatency, newSelectorRand(1))
+	for i := 0; i < 100; i++ {
+		if service := selector.SelectService(); service == nil || service == services[0] {
+			t.Fatalf("expected an alive service to be selected, got %v", service)
+		}
+	}
+
+	services[1].FSM.CurrentState = StateDown
+	services[2].FSM.CurrentState = StateDown
+	if service := selector.SelectService(); service != nil {
+		t.Errorf("expected no service to be selected, got %s", service.Name)
+	}
+}
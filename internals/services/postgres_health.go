@@ -0,0 +1,334 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// postgresProtocolVersion is version 3.0 of the frontend/backend protocol
+const postgresProtocolVersion = 3 << 16
+
+// postgresMaxMessageSize caps the size of the messages read during a check
+const postgresMaxMessageSize = 1 << 20
+
+// postgresMaxSCRAMIterations caps the iteration count a server may ask for, deriving the key can't be
+// cancelled so a larger count could hold a check far past its timeout
+const postgresMaxSCRAMIterations = 100_000
+
+// Authentication request codes sent by the server in an 'R' message
+const (
+	postgresAuthOK           = 0
+	postgresAuthCleartext    = 3
+	postgresAuthMD5          = 5
+	postgresAuthSASL         = 10
+	postgresAuthSASLContinue = 11
+	postgresAuthSASLFinal    = 12
+)
+
+// PostgresHealthChecker considers a service healthy if it can be logged into and runs Query without an error.
+// Cleartext, MD5 and SCRAM-SHA-256 authentication are supported
+type PostgresHealthChecker struct {
+	// Port overrides the port of the service when set
+	Port int
+
+	Username string
+	Password string
+
+	// Database defaults to the name of the user like it does for every PostgreSQL client
+	Database string
+
+	// Query defaults to SELECT 1
+	Query string
+}
+
+func (c *PostgresHealthChecker) Check(ctx context.Context, svc *Service) error {
+	conn, err := dialHealthCheck(ctx, svc, c.Port)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	pg := &postgresConn{conn: conn, reader: bufio.NewReader(conn)}
+	if err := pg.startup(c.Username, c.Database); err != nil {
+		return err
+	}
+
+	if err := pg.authenticate(c.Username, c.Password); err != nil {
+		return err
+	}
+
+	query := c.Query
+	if query == "" {
+		query = "SELECT 1"
+	}
+
+	if err := pg.query(query); err != nil {
+		return err
+	}
+
+	// Terminate lets the server end the session cleanly instead of logging an unexpected EOF
+	return pg.send('X', nil)
+}
+
+// postgresConn speaks the frontend side of the PostgreSQL protocol
+type postgresConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// startup sends the startup message opening the session
+func (pg *postgresConn) startup(username string, database string) error {
+	var payload []byte
+	payload = binary.BigEndian.AppendUint32(payload, postgresProtocolVersion)
+	payload = append(payload, "user\x00"+username+"\x00"...)
+	if database != "" {
+		payload = append(payload, "database\x00"+database+"\x00"...)
+	}
+	payload = append(payload, 0)
+
+	message := binary.BigEndian.AppendUint32(nil, uint32(len(payload)+4))
+	_, err := pg.conn.Write(append(message, payload...))
+
+	return err
+}
+
+// authenticate answers the authentication requests of the server until it is ready for queries
+func (pg *postgresConn) authenticate(username string, password string) error {
+	for {
+		kind, payload, err := pg.receive()
+		if err != nil {
+			return err
+		}
+
+		switch kind {
+		case 'Z':
+			return nil
+		case 'R':
+			if len(payload) < 4 {
+				return errors.New("malformed postgres authentication request")
+			}
+
+			switch code := binary.BigEndian.Uint32(payload); code {
+			case postgresAuthOK:
+			case postgresAuthCleartext:
+				err = pg.send('p', append([]byte(password), 0))
+			case postgresAuthMD5:
+				if len(payload) < 8 {
+					return errors.New("malformed postgres MD5 authentication request")
+				}
+
+				err = pg.send('p', append([]byte(postgresMD5Password(username, password, payload[4:8])), 0))
+			case postgresAuthSASL:
+				err = pg.authenticateSCRAM(password, payload[4:])
+			default:
+				return fmt.Errorf("unsupported postgres authentication method %d", code)
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// authenticateSCRAM runs a SCRAM-SHA-256 exchange, mechanisms lists the mechanisms offered by the server
+func (pg *postgresConn) authenticateSCRAM(password string, mechanisms []byte) error {
+	if !slices.Contains(strings.Split(string(mechanisms), "\x00"), "SCRAM-SHA-256") {
+		return errors.New("postgres doesn't offer SCRAM-SHA-256 authentication")
+	}
+
+	nonceBytes := make([]byte, 18)
+	_, _ = rand.Read(nonceBytes)
+	clientNonce := base64.StdEncoding.EncodeToString(nonceBytes)
+
+	// The user name is taken from the startup message, so it is left empty here
+	clientFirstBare := "n=,r=" + clientNonce
+	clientFirst := "n,," + clientFirstBare
+
+	initial := append([]byte("SCRAM-SHA-256\x00"), binary.BigEndian.AppendUint32(nil, uint32(len(clientFirst)))...)
+	if err := pg.send('p', append(initial, clientFirst...)); err != nil {
+		return err
+	}
+
+	serverFirst, err := pg.receiveSASL(postgresAuthSASLContinue)
+	if err != nil {
+		return err
+	}
+
+	attributes := parseSCRAMAttributes(serverFirst)
+	serverNonce, salt := attributes["r"], attributes["s"]
+	iterations, iterationsErr := strconv.Atoi(attributes["i"])
+	decodedSalt, saltErr := base64.StdEncoding.DecodeString(salt)
+	if !strings.HasPrefix(serverNonce, clientNonce) || iterationsErr != nil || iterations < 1 || saltErr != nil {
+		return errors.New("malformed postgres SCRAM challenge")
+	}
+
+	if iterations > postgresMaxSCRAMIterations {
+		return fmt.Errorf("postgres asked for %d SCRAM iterations, at most %d are allowed", iterations, postgresMaxSCRAMIterations)
+	}
+
+	saltedPassword, err := pbkdf2.Key(sha256.New, password, decodedSalt, iterations, sha256.Size)
+	if err != nil {
+		return err
+	}
+
+	clientFinalWithoutProof := "c=biws,r=" + serverNonce
+	authMessage := clientFirstBare + "," + serverFirst + "," + clientFinalWithoutProof
+
+	clientKey := hmacSHA256(saltedPassword, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+	proof := hmacSHA256(storedKey[:], authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+
+	clientFinal := clientFinalWithoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof)
+	if err := pg.send('p', []byte(clientFinal)); err != nil {
+		return err
+	}
+
+	serverFinal, err := pg.receiveSASL(postgresAuthSASLFinal)
+	if err != nil {
+		return err
+	}
+
+	// The server proves it knows the password too, which protects against a spoofed server passing checks
+	serverSignature := hmacSHA256(hmacSHA256(saltedPassword, "Server Key"), authMessage)
+	verifier, err := base64.StdEncoding.DecodeString(parseSCRAMAttributes(serverFinal)["v"])
+	if err != nil || !hmac.Equal(verifier, serverSignature) {
+		return errors.New("postgres SCRAM server signature mismatch")
+	}
+
+	return nil
+}
+
+// receiveSASL waits for the SASL authentication message with the expected code and returns its data
+func (pg *postgresConn) receiveSASL(expected uint32) (string, error) {
+	kind, payload, err := pg.receive()
+	if err != nil {
+		return "", err
+	}
+
+	if kind != 'R' || len(payload) < 4 || binary.BigEndian.Uint32(payload) != expected {
+		return "", errors.New("unexpected message during postgres SCRAM authentication")
+	}
+
+	return string(payload[4:]), nil
+}
+
+// query runs a simple query and waits for the server to be ready again
+func (pg *postgresConn) query(query string) error {
+	if err := pg.send('Q', append([]byte(query), 0)); err != nil {
+		return err
+	}
+
+	for {
+		kind, _, err := pg.receive()
+		if err != nil {
+			return err
+		}
+
+		if kind == 'Z' {
+			return nil
+		}
+	}
+}
+
+// send writes a message of the given kind
+func (pg *postgresConn) send(kind byte, payload []byte) error {
+	message := binary.BigEndian.AppendUint32([]byte{kind}, uint32(len(payload)+4))
+	_, err := pg.conn.Write(append(message, payload...))
+
+	return err
+}
+
+// receive reads the next message, error responses are returned as errors and notices are skipped
+func (pg *postgresConn) receive() (byte, []byte, error) {
+	for {
+		var header [5]byte
+		if _, err := io.ReadFull(pg.reader, header[:]); err != nil {
+			return 0, nil, err
+		}
+
+		length := binary.BigEndian.Uint32(header[1:])
+		if length < 4 || length > postgresMaxMessageSize {
+			return 0, nil, fmt.Errorf("invalid postgres message length %d", length)
+		}
+
+		payload := make([]byte, length-4)
+		if _, err := io.ReadFull(pg.reader, payload); err != nil {
+			return 0, nil, err
+		}
+
+		switch header[0] {
+		case 'E':
+			return 0, nil, postgresError(payload)
+		case 'N':
+			continue
+		}
+
+		return header[0], payload, nil
+	}
+}
+
+// postgresError turns the fields of an error response into an error
+func postgresError(payload []byte) error {
+	var code, message string
+	for _, field := range bytes.Split(payload, []byte{0}) {
+		if len(field) == 0 {
+			continue
+		}
+
+		switch field[0] {
+		case 'C':
+			code = string(field[1:])
+		case 'M':
+			message = string(field[1:])
+		}
+	}
+
+	return fmt.Errorf("postgres error %s: %s", code, message)
+}
+
+// postgresMD5Password returns the response to an MD5 authentication request, md5(md5(password + user) + salt)
+func postgresMD5Password(username string, password string, salt []byte) string {
+	inner := md5.Sum([]byte(password + username))
+	outer := md5.Sum(append([]byte(hex.EncodeToString(inner[:])), salt...))
+
+	return "md5" + hex.EncodeToString(outer[:])
+}
+
+// parseSCRAMAttributes parses the comma separated key=value attributes of a SCRAM message
+func parseSCRAMAttributes(message string) map[string]string {
+	attributes := make(map[string]string)
+	for _, attribute := range strings.Split(message, ",") {
+		if key, value, ok := strings.Cut(attribute, "="); ok {
+			attributes[key] = value
+		}
+	}
+
+	return attributes
+}
+
+func hmacSHA256(key []byte, message string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// serveTestPostgres logs the client in with the authentication method and answers SELECT 1, any other
+// query fails. Only the md5 and SCRAM-SHA-256 methods are implemented, SCRAM uses the iteration count
+func serveTestPostgres(method uint32, username string, password string, iterations int) func(conn net.Conn) {
+	return func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		pg := &postgresConn{conn: conn, reader: reader}
+		fail := func() { _ = pg.send('E', []byte("SFATAL\x00C28P01\x00Mpassword authentication failed\x00\x00")) }
+
+		// The startup message has no kind byte
+		var length [4]byte
+		if _, err := io.ReadFull(reader, length[:]); err != nil {
+			return
+		}
+
+		startup := make([]byte, binary.BigEndian.Uint32(length[:])-4)
+		if _, err := io.ReadFull(reader, startup); err != nil || !bytes.Contains(startup, []byte("user\x00"+username+"\x00")) {
+			fail()
+			return
+		}
+
+		switch method {
+		case postgresAuthMD5:
+			salt := []byte{1, 2, 3, 4}
+			_ = pg.send('R', append(binary.BigEndian.AppendUint32(nil, postgresAuthMD5), salt...))
+
+			_, response, err := pg.receive()
+			if err != nil || string(response) != postgresMD5Password(username, password, salt)+"\x00" {
+				fail()
+				return
+			}
+		case postgresAuthSASL:
+			if !serveTestSCRAM(pg, password, iterations) {
+				fail()
+				return
+			}
+		}
+
+		_ = pg.send('R', binary.BigEndian.AppendUint32(nil, postgresAuthOK))
+		_ = pg.send('S', []byte("server_version\x0017.0\x00"))
+		_ = pg.send('Z', []byte{'I'})
+
+		for {
+			kind, payload, err := pg.receive()
+			if err != nil || kind != 'Q' {
+				return
+			}
+
+			if string(payload) != "SELECT 1\x00" {
+				_ = pg.send('E', []byte("SERROR\x00C42601\x00Msyntax error\x00\x00"))
+				return
+			}
+
+			_ = pg.send('C', []byte("SELECT 1\x00"))
+			_ = pg.send('Z', []byte{'I'})
+		}
+	}
+}
+
+// serveTestSCRAM runs the server side of a SCRAM-SHA-256 exchange and reports whether the client proved
+// it knows the password
+func serveTestSCRAM(pg *postgresConn, password string, iterations int) bool {
+	_ = pg.send('R', append(binary.BigEndian.AppendUint32(nil, postgresAuthSASL), "SCRAM-SHA-256\x00\x00"...))
+
+	_, initial, err := pg.receive()
+	if err != nil || !bytes.HasPrefix(initial, []byte("SCRAM-SHA-256\x00")) {
+		return false
+	}
+
+	clientFirstBare := strings.TrimPrefix(string(initial[len("SCRAM-SHA-256\x00")+4:]), "n,,")
+	salt := []byte("splitbit-salt")
+	serverFirst := "r=" + parseSCRAMAttributes(clientFirstBare)["r"] + "server-nonce,s=" + base64.StdEncoding.EncodeToString(salt) + ",i=" + strconv.Itoa(iterations)
+	_ = pg.send('R', append(binary.BigEndian.AppendUint32(nil, postgresAuthSASLContinue), serverFirst...))
+
+	_, clientFinal, err := pg.receive()
+	if err != nil {
+		return false
+	}
+
+	withoutProof, encodedProof, _ := strings.Cut(string(clientFinal), ",p=")
+	proof, _ := base64.StdEncoding.DecodeString(encodedProof)
+	authMessage := clientFirstBare + "," + serverFirst + "," + withoutProof
+
+	saltedPassword, _ := pbkdf2.Key(sha256.New, password, salt, iterations, sha256.Size)
+	storedKey := sha256.Sum256(hmacSHA256(saltedPassword, "Client Key"))
+	clientSignature := hmacSHA256(storedKey[:], authMessage)
+	if len(proof) != len(clientSignature) {
+		return false
+	}
+
+	// XOR-ing the proof with the signature recovers the client key, which must hash to the stored key
+	for i := range proof {
+		proof[i] ^= clientSignature[i]
+	}
+
+	if recovered := sha256.Sum256(proof); !hmac.Equal(recovered[:], storedKey[:]) {
+		return false
+	}
+
+	serverSignature := hmacSHA256(hmacSHA256(saltedPassword, "Server Key"), authMessage)
+	serverFinal := "v=" + base64.StdEncoding.EncodeToString(serverSignature)
+	_ = pg.send('R', append(binary.BigEndian.AppendUint32(nil, postgresAuthSASLFinal), serverFinal...))
+
+	return true
+}
+
+func TestPostgresHealthChecker(t *testing.T) {
+	md5Server := newTestProtocolServer(t, serveTestPostgres(postgresAuthMD5, "splitbit", "secret", 0))
+	scramServer := newTestProtocolServer(t, serveTestPostgres(postgresAuthSASL, "splitbit", "secret", 4096))
+	costlyServer := newTestProtocolServer(t, serveTestPostgres(postgresAuthSASL, "splitbit", "secret", 1_000_000_000))
+
+	tests := []struct {
+		name    string
+		service *Service
+		checker *PostgresHealthChecker
+		healthy bool
+	}{
+		{"with md5", md5Server, &PostgresHealthChecker{Username: "splitbit", Password: "secret"}, true},
+		{"with a wrong md5 password", md5Server, &PostgresHealthChecker{Username: "splitbit", Password: "guess"}, false},
+		{"with SCRAM", scramServer, &PostgresHealthChecker{Username: "splitbit", Password: "secret", Database: "app"}, true},
+		{"with a wrong SCRAM password", scramServer, &PostgresHealthChecker{Username: "splitbit", Password: "guess"}, false},
+		{"with too many SCRAM iterations", costlyServer, &PostgresHealthChecker{Username: "splitbit", Password: "secret"}, false},
+		{"with a failing query", scramServer, &PostgresHealthChecker{Username: "splitbit", Password: "secret", Query: "SELEC 1"}, false},
+	}
+
+	for _, test := range tests {
+		err := checkTestService(test.checker, test.service)
+		if test.healthy && err != nil {
+			t.Errorf("%s: expected the check to pass, got %v", test.name, err)
+		} else if !test.healthy && err == nil {
+			t.Errorf("%s: expected the check to fail", test.name)
+		}
+	}
+}
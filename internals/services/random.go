@@ -0,0 +1,149 @@
+package services
+
+import (
+	"math/rand/v2"
+	"sync"
+)
+
+// RandomSelector selects an alive service uniformly at random
+type RandomSelector struct {
+	services []*Service
+	rng      *rand.Rand
+	mu       sync.Mutex
+}
+
+func NewRandomSelector(services []*Service, rng *rand.Rand) *RandomSelector {
+	return &RandomSelector{
+		services: services,
+		rng:      rng,
+	}
+}
+
+func (r *RandomSelector) SelectService() *Service {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	alive := make([]*Service, 0, len(r.services))
+	for _, svc := range r.services {
+		if svc.Available() {
+			alive = append(alive, svc)
+		}
+	}
+
+	if len(alive) == 0 {
+		return nil
+	}
+
+	return alive[r.rng.IntN(len(alive))]
+}
+
+// WeightedRandomSelector selects an alive service at random with a probability proportional to its weight
+type WeightedRandomSelector struct {
+	services []*Service
+	rng      *rand.Rand
+	mu       sync.Mutex
+}
+
+func NewWeightedRandomSelector(services []*Service, rng *rand.Rand) *WeightedRandomSelector {
+	return &WeightedRandomSelector{
+		services: services,
+		rng:      rng,
+	}
+}
+
+func (wr *WeightedRandomSelector) SelectService() *Service {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	var total float64
+	for _, svc := range wr.services {
+		if svc.Available() {
+			total += svc.EffectiveWeight()
+		}
+	}
+
+	if total <= 0 {
+		return nil
+	}
+
+	point := wr.rng.Float64() * total
+
+	var selected *Service
+	for _, svc := range wr.services {
+		weight := svc.EffectiveWeight()
+		if !svc.Available() || weight <= 0 {
+			continue
+		}
+
+		selected = svc
+		if point < weight {
+			break
+		}
+
+		point -= weight
+	}
+
+	return selected
+}
+
+// P2CSelector implements the power of two choices, it samples two random alive services and selects the one
+// with fewer active connections. Sampling keeps several splitbit instances in front of the same services from
+// moving in lockstep while still steering away from loaded services
+type P2CSelector struct {
+	services []*Service
+	rng      *rand.Rand
+	mu       sync.Mutex
+}
+
+func NewP2CSelector(services []*Service, rng *rand.Rand) *P2CSelector {
+	return &P2CSelector{
+		services: services,
+		rng:      rng,
+	}
+}
+
+func (p *P2CSelector) SelectService() *Service {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	first, second := pickTwoAlive(p.services, p.rng)
+	if second != nil && second.ActiveConnections() < first.ActiveConnections() {
+		return second
+	}
+
+	return first
+}
+
+// pickTwoAlive picks two distinct random alive services with a weight, second is nil if fewer than two qualify
+func pickTwoAlive(services []*Service, rng *rand.Rand) (first *Service, second *Service) {
+	alive := make([]*Service, 0, len(services))
+	for _, svc := range services {
+		if svc.Available() && svc.EffectiveWeight() > 0 {
+			alive = append(alive, svc)
+		}
+	}
+
+	switch len(alive) {
+	case 0:
+		return nil, nil
+	case 1:
+		return alive[0], nil
+	}
+
+	i := rng.IntN(len(alive))
+	j := rng.IntN(len(alive) - 1)
+	if j >= i {
+		j++
+	}
+
+	return alive[i], alive[j]
+}
+
+// newSelectorRand returns a random number generator for a selector, a seed of 0 picks a random seed
+func newSelectorRand(seed uint64) *rand.Rand {
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+
+	return rand.New(rand.NewPCG(seed, seed))
+}
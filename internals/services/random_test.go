@@ -0,0 +1,104 @@
+package services
+
+import "testing"
+
+func TestRandomSelectorIsDeterministicWithSeed(t *testing.T) {
+	services := newTestServices(5)
+	first := NewRandomSelector(services, newSelectorRand(42))
+	second := NewRandomSelector(services, newSelectorRand(42))
+
+	for i := 0; i < 100; i++ {
+		if a, b := first.SelectService(), second.SelectService(); a != b {
+			t.Fatalf("selectors with the same seed diverged at %d: %s != %s", i, a.Name, b.Name)
+		}
+	}
+}
+
+func TestRandomSelectorDistribution(t *testing.T) {
+	services := newTestServices(4)
+	services[3].FSM.CurrentState = StateDown
+
+	selector := NewRandomSelector(services, newSelectorRand(7))
+
+	counts := map[*Service]int{}
+	for i := 0; i < 9000; i++ {
+		counts[selector.SelectService()]++
+	}
+
+	if counts[services[3]] != 0 {
+		t.Errorf("a service which is down was selected %d times", counts[services[3]])
+	}
+
+	for _, service := range services[:3] {
+		if counts[service] < 2700 || counts[service] > 3300 {
+			t.Errorf("expected about 3000 selections for %s, got %d", service.Name, counts[service])
+		}
+	}
+}
+
+func TestWeightedRandomSelectorDistribution(t *testing.T) {
+	services := newTestServices(3)
+	services[0].Weight = 6
+	services[1].Weight = 3
+	services[2].Weight = 1
+
+	selector := NewWeightedRandomSelector(services, newSelectorRand(7))
+
+	counts := map[*Service]int{}
+	for i := 0; i < 10000; i++ {
+		counts[selector.SelectService()]++
+	}
+
+	expected := []int{6000, 3000, 1000}
+	for i, service := range services {
+		if diff := counts[service] - expected[i]; diff < -300 || diff > 300 {
+			t.Errorf("expected about %d selections for %s, got %d", expected[i], service.Name, counts[service])
+		}
+	}
+}
+
+func TestP2CSelectorPrefersFewerConnections(t *testing.T) {
+	services := newTestServices(2)
+	services[0].ConnectionCount = 10
+
+	selector := NewP2CSelector(services, newSelectorRand(3))
+	for i := 0; i < 10; i++ {
+		if service := selector.SelectService(); service != services[1] {
+			t.Fatalf("expected the less loaded service to be selected, got %s", service.Name)
+		}
+	}
+}
+
+func TestP2CSelectorBalancesConnections(t *testing.T) {
+	services := newTestServices(5)
+	selector := NewP2CSelector(services, newSelectorRand(3))
+
+	for i := 0; i < 500; i++ {
+		selector.SelectService().AcquireConnection()
+	}
+
+	for _, service := range services {
+		if count := service.ActiveConnections(); count < 90 || count > 110 {
+			t.Errorf("expected about 100 connections for %s, got %d", service.Name, count)
+		}
+	}
+}
+
+func TestRandomSelectorsWithoutAliveServices(t *testing.T) {
+	services := newTestServices(2)
+	for _, service := range services {
+		service.FSM.CurrentState = StateDown
+	}
+
+	selectors := []ClientUnawareSelector{
+		NewRandomSelector(services, newSelectorRand(1)),
+		NewWeightedRandomSelector(services, newSelectorRand(1)),
+		NewP2CSelector(services, newSelectorRand(1)),
+	}
+
+	for _, selector := range selectors {
+		if service := selector.SelectService(); service != nil {
+			t.Errorf("%T selected %s although no service is alive", selector, service.Name)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// RedisHealthChecker considers a service healthy if it answers a PING with PONG and, when Role is set, reports
+// that replication role. Servers requiring authentication are sent an AUTH first
+type RedisHealthChecker struct {
+	// Port overrides the port of the service when set
+	Port int
+
+	// Username is sent along the password for servers using ACLs
+	Username string
+	Password string
+
+	// Role is either master or replica, any role passes when it is empty
+	Role string
+}
+
+func (c *RedisHealthChecker) Check(ctx context.Context, svc *Service) error {
+	conn, err := dialHealthCheck(ctx, svc, c.Port)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	reader := bufio.NewReader(conn)
+
+	if c.Password != "" {
+		args := []string{"AUTH", c.Password}
+		if c.Username != "" {
+			args = []string{"AUTH", c.Username, c.Password}
+		}
+
+		if _, err := redisCommand(conn, reader, args...); err != nil {
+			return fmt.Errorf("redis AUTH failed: %w", err)
+		}
+	}
+
+	reply, err := redisCommand(conn, reader, "PING")
+	if err != nil {
+		return fmt.Errorf("redis PING failed: %w", err)
+	}
+
+	if reply != "+PONG" {
+		return fmt.Errorf("unexpected redis PING reply %q", reply)
+	}
+
+	if c.Role == "" {
+		return nil
+	}
+
+	role, err := redisRole(conn, reader)
+	if err != nil {
+		return fmt.Errorf("redis ROLE failed: %w", err)
+	}
+
+	if role != c.Role {
+		return fmt.Errorf("redis role is %s instead of %s", role, c.Role)
+	}
+
+	return nil
+}
+
+// redisCommand sends a command and returns the first line of its reply, error replies are returned as errors
+func redisCommand(conn net.Conn, reader *bufio.Reader, args ...string) (string, error) {
+	var command strings.Builder
+	fmt.Fprintf(&command, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&command, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	if _, err := io.WriteString(conn, command.String()); err != nil {
+		return "", err
+	}
+
+	line, err := readRedisLine(reader)
+	if err != nil {
+		return "", err
+	}
+
+	if message, ok := strings.CutPrefix(line, "-"); ok {
+		return "", errors.New(message)
+	}
+
+	return line, nil
+}
+
+// redisRole returns the replication role reported by ROLE, the first element of its reply. Replicas report
+// themselves as slave which is returned as replica
+func redisRole(conn net.Conn, reader *bufio.Reader) (string, error) {
+	reply, err := redisCommand(conn, reader, "ROLE")
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.HasPrefix(reply, "*") {
+		return "", fmt.Errorf("unexpected reply %q", reply)
+	}
+
+	header, err := readRedisLine(reader)
+	if err != nil {
+		return "", err
+	}
+
+	length, err := strconv.Atoi(strings.TrimPrefix(header, "$"))
+	if !strings.HasPrefix(header, "$") || err != nil || length < 0 || length > 64 {
+		return "", fmt.Errorf("unexpected reply %q", header)
+	}
+
+	role := make([]byte, length+2)
+	if _, err := io.ReadFull(reader, role); err != nil {
+		return "", err
+	}
+
+	if string(role[:length]) == "slave" {
+		return "replica", nil
+	}
+
+	return string(role[:length]), nil
+}
+
+// readRedisLine reads a single CRLF terminated line of a reply
+func readRedisLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
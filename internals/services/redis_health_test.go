@@ -0,0 +1,104 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// serveTestRedis answers AUTH, PING and ROLE like a Redis server with the password and replication role
+func serveTestRedis(password string, role string) func(conn net.Conn) {
+	return func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		authenticated := password == ""
+
+		for {
+			args, err := readTestRedisCommand(reader)
+			if err != nil {
+				return
+			}
+
+			var reply string
+			switch {
+			case args[0] == "AUTH" && args[len(args)-1] == password:
+				authenticated = true
+				reply = "+OK\r\n"
+			case args[0] == "AUTH":
+				reply = "-WRONGPASS invalid username-password pair\r\n"
+			case !authenticated:
+				reply = "-NOAUTH Authentication required.\r\n"
+			case args[0] == "PING":
+				reply = "+PONG\r\n"
+			case args[0] == "ROLE":
+				reply = fmt.Sprintf("*3\r\n$%d\r\n%s\r\n:0\r\n*0\r\n", len(role), role)
+			default:
+				reply = "-ERR unknown command\r\n"
+			}
+
+			if _, err := conn.Write([]byte(reply)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readTestRedisCommand reads a command sent as an array of bulk strings
+func readTestRedisCommand(reader *bufio.Reader) ([]string, error) {
+	header, err := readRedisLine(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := strconv.Atoi(strings.TrimPrefix(header, "*"))
+	if err != nil || count < 1 {
+		return nil, fmt.Errorf("unexpected command header %q", header)
+	}
+
+	args := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		if _, err := readRedisLine(reader); err != nil {
+			return nil, err
+		}
+
+		arg, err := readRedisLine(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, arg)
+	}
+
+	return args, nil
+}
+
+func TestRedisHealthChecker(t *testing.T) {
+	master := newTestProtocolServer(t, serveTestRedis("secret", "master"))
+	replica := newTestProtocolServer(t, serveTestRedis("", "slave"))
+
+	tests := []struct {
+		name    string
+		service *Service
+		checker *RedisHealthChecker
+		healthy bool
+	}{
+		{"with the password", master, &RedisHealthChecker{Password: "secret"}, true},
+		{"with an ACL user", master, &RedisHealthChecker{Username: "splitbit", Password: "secret"}, true},
+		{"without the password", master, &RedisHealthChecker{}, false},
+		{"with a wrong password", master, &RedisHealthChecker{Password: "guess"}, false},
+		{"requiring a master", master, &RedisHealthChecker{Password: "secret", Role: "master"}, true},
+		{"requiring a master of a replica", replica, &RedisHealthChecker{Role: "master"}, false},
+		{"requiring a replica", replica, &RedisHealthChecker{Role: "replica"}, true},
+	}
+
+	for _, test := range tests {
+		err := checkTestService(test.checker, test.service)
+		if test.healthy && err != nil {
+			t.Errorf("%s: expected the check to pass, got %v", test.name, err)
+		} else if !test.healthy && err == nil {
+			t.Errorf("%s: expected the check to fail", test.name)
+		}
+	}
+}
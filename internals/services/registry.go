@@ -0,0 +1,303 @@
+package services
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/frostzt/splitbit/internals"
+)
+
+// SelectorOptions are the algorithm specific options provided through the `algorithm_options` configuration key
+type SelectorOptions map[string]string
+
+// SelectorFactory builds a BackendSelector for the provided services, factories must validate their options
+// even when no services are provided since the configuration is validated that way
+type SelectorFactory func(services []*Service, opts SelectorOptions) (BackendSelector, error)
+
+var (
+	// selectors maps algorithm names to the factory building their selector
+	selectors   = map[string]SelectorFactory{}
+	selectorsMu sync.RWMutex
+)
+
+func init() {
+	RegisterSelector("round-robin", func(services []*Service, opts SelectorOptions) (BackendSelector, error) {
+		if err := opts.allow(); err != nil {
+			return nil, err
+		}
+
+		return AdaptSelector(NewRoundRobinSelector(services)), nil
+	})
+
+	RegisterSelector("weighted-round-robin", func(services []*Service, opts SelectorOptions) (BackendSelector, error) {
+		if err := opts.allow(); err != nil {
+			return nil, err
+		}
+
+		return AdaptSelector(NewWeightedRoundRobin(services)), nil
+	})
+
+	RegisterSelector("least-connections", func(services []*Service, opts SelectorOptions) (BackendSelector, error) {
+		if err := opts.allow(); err != nil {
+			return nil, err
+		}
+
+		return AdaptSelector(NewLeastConnectionsSelector(services)), nil
+	})
+
+	RegisterSelector("weighted-least-connections", func(services []*Service, opts SelectorOptions) (BackendSelector, error) {
+		if err := opts.allow(); err != nil {
+			return nil, err
+		}
+
+		return AdaptSelector(NewWeightedLeastConnectionsSelector(services)), nil
+	})
+
+	RegisterSelector("consistent-hashing", func(services []*Service, opts SelectorOptions) (BackendSelector, error) {
+		if err := opts.allow("key", "replicas"); err != nil {
+			return nil, err
+		}
+
+		key, err := ParseHashKey(opts.String("key", string(HashKeySourceIP)))
+		if err != nil {
+			return nil, err
+		}
+
+		replicas, err := opts.Int("replicas", defaultRingReplicas)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewConsistentHashSelector(services, key, replicas)
+	})
+
+	RegisterSelector("bounded-load-consistent-hashing", func(services []*Service, opts SelectorOptions) (BackendSelector, error) {
+		if err := opts.allow("key", "replicas", "load_factor"); err != nil {
+			return nil, err
+		}
+
+		key, err := ParseHashKey(opts.String("key", string(HashKeySourceIP)))
+		if err != nil {
+			return nil, err
+		}
+
+		replicas, err := opts.Int("replicas", defaultRingReplicas)
+		if err != nil {
+			return nil, err
+		}
+
+		loadFactor, err := opts.Float("load_factor", defaultLoadFactor)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewBoundedLoadSelector(services, key, replicas, loadFactor)
+	})
+
+	RegisterSelector("peak-ewma", func(services []*Service, opts SelectorOptions) (BackendSelector, error) {
+		if err := opts.allow("seed", "unobserved_latency"); err != nil {
+			return nil, err
+		}
+
+		seed, err := opts.Uint("seed", 0)
+		if err != nil {
+			return nil, err
+		}
+
+		unobservedLatency, err := opts.Duration("unobserved_latency", defaultUnobservedLatency)
+		if err != nil {
+			return nil, err
+		}
+
+		return AdaptSelector(NewPeakEWMASelector(services, unobservedLatency, newSelectorRand(seed))), nil
+	})
+
+	RegisterSelector("random", func(services []*Service, opts SelectorOptions) (BackendSelector, error) {
+		if err := opts.allow("seed"); err != nil {
+			return nil, err
+		}
+
+		seed, err := opts.Uint("seed", 0)
+		if err != nil {
+			return nil, err
+		}
+
+		return AdaptSelector(NewRandomSelector(services, newSelectorRand(seed))), nil
+	})
+
+	RegisterSelector("weighted-random", func(services []*Service, opts SelectorOptions) (BackendSelector, error) {
+		if err := opts.allow("seed"); err != nil {
+			return nil, err
+		}
+
+		seed, err := opts.Uint("seed", 0)
+		if err != nil {
+			return nil, err
+		}
+
+		return AdaptSelector(NewWeightedRandomSelector(services, newSelectorRand(seed))), nil
+	})
+
+	RegisterSelector("power-of-two-choices", func(services []*Service, opts SelectorOptions) (BackendSelector, error) {
+		if err := opts.allow("seed"); err != nil {
+			return nil, err
+		}
+
+		seed, err := opts.Uint("seed", 0)
+		if err != nil {
+			return nil, err
+		}
+
+		return AdaptSelector(NewP2CSelector(services, newSelectorRand(seed))), nil
+	})
+
+	RegisterSelector("maglev", func(services []*Service, opts SelectorOptions) (BackendSelector, error) {
+		if err := opts.allow("key", "table_size"); err != nil {
+			return nil, err
+		}
+
+		key, err := ParseHashKey(opts.String("key", string(HashKeySourceIP)))
+		if err != nil {
+			return nil, err
+		}
+
+		tableSize, err := opts.Int("table_size", defaultMaglevTableSize)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewMaglevSelector(services, key, tableSize)
+	})
+
+	RegisterSelector("rendezvous", func(services []*Service, opts SelectorOptions) (BackendSelector, error) {
+		if err := opts.allow("key"); err != nil {
+			return nil, err
+		}
+
+		key, err := ParseHashKey(opts.String("key", string(HashKeySourceIP)))
+		if err != nil {
+			return nil, err
+		}
+
+		return NewRendezvousSelector(services, key), nil
+	})
+}
+
+// RegisterSelector makes an algorithm available under the provided name, the algorithm is also registered
+// with the configuration so that validation and selector construction are driven by the same registry
+func RegisterSelector(name string, factory SelectorFactory) {
+	selectorsMu.Lock()
+	defer selectorsMu.Unlock()
+
+	if _, exists := selectors[name]; exists {
+		panic(fmt.Sprintf("selector %s is already registered", name))
+	}
+
+	selectors[name] = factory
+	internals.RegisterAlgorithm(name, func(options map[string]string) error {
+		_, err := factory(nil, options)
+		return err
+	})
+}
+
+// NewSelector builds the selector registered under the provided algorithm name
+func NewSelector(name string, services []*Service, opts SelectorOptions) (BackendSelector, error) {
+	selectorsMu.RLock()
+	factory, ok := selectors[name]
+	selectorsMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown algorithm %s", name)
+	}
+
+	selector, err := factory(services, opts)
+	if err != nil {
+		return nil, fmt.Errorf("algorithm %s: %w", name, err)
+	}
+
+	return selector, nil
+}
+
+// allow returns an error if any option other than the provided keys is set
+func (opts SelectorOptions) allow(keys ...string) error {
+	for key := range opts {
+		if !slices.Contains(keys, key) {
+			return fmt.Errorf("unknown option %s", key)
+		}
+	}
+
+	return nil
+}
+
+// Int returns the integer option for the key or the fallback if it isn't set
+func (opts SelectorOptions) Int(key string, fallback int) (int, error) {
+	value, ok := opts[key]
+	if !ok || value == "" {
+		return fallback, nil
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("option %s must be an integer, found %q", key, value)
+	}
+
+	return parsed, nil
+}
+
+// Uint returns the unsigned integer option for the key or the fallback if it isn't set
+func (opts SelectorOptions) Uint(key string, fallback uint64) (uint64, error) {
+	value, ok := opts[key]
+	if !ok || value == "" {
+		return fallback, nil
+	}
+
+	parsed, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("option %s must be a positive integer, found %q", key, value)
+	}
+
+	return parsed, nil
+}
+
+// Float returns the float option for the key or the fallback if it isn't set
+func (opts SelectorOptions) Float(key string, fallback float64) (float64, error) {
+	value, ok := opts[key]
+	if !ok || value == "" {
+		return fallback, nil
+	}
+
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("option %s must be a number, found %q", key, value)
+	}
+
+	return parsed, nil
+}
+
+// Duration returns the duration option for the key or the fallback if it isn't set
+func (opts SelectorOptions) Duration(key string, fallback time.Duration) (time.Duration, error) {
+	value, ok := opts[key]
+	if !ok || value == "" {
+		return fallback, nil
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("option %s must be a duration, found %q", key, value)
+	}
+
+	return parsed, nil
+}
+
+// String returns the option for the key or the fallback if it isn't set
+func (opts SelectorOptions) String(key string, fallback string) string {
+	value, ok := opts[key]
+	if !ok || value == "" {
+		return fallback
+	}
+
+	return value
+}
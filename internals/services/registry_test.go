@@ -0,0 +1,70 @@
+package services
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/frostzt/splitbit/internals"
+)
+
+func TestNewSelector(t *testing.T) {
+	selector, err := NewSelector("least-connections", newTestServices(2), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adapter, ok := selector.(*clientUnawareAdapter)
+	if !ok {
+		t.Fatalf("expected an adapted selector, got %T", selector)
+	}
+
+	if _, ok := adapter.selector.(*LeastConnectionsSelector); !ok {
+		t.Errorf("expected a least connections selector, got %T", adapter.selector)
+	}
+
+	if _, err := NewSelector("round-batman", newTestServices(2), nil); err == nil {
+		t.Error("expected an error for an unknown algorithm")
+	}
+
+	_, err = NewSelector("round-robin", newTestServices(2), SelectorOptions{"virtual_nodes": "10"})
+	if err == nil || !strings.Contains(err.Error(), "unknown option virtual_nodes") {
+		t.Errorf("expected an unknown option error, got %v", err)
+	}
+}
+
+func TestRegisteredSelectorsAreValidAlgorithms(t *testing.T) {
+	selectorsMu.RLock()
+	defer selectorsMu.RUnlock()
+
+	algorithms := internals.Algorithms()
+	for name := range selectors {
+		if !slices.Contains(algorithms, name) {
+			t.Errorf("selector %s is not registered as a configuration algorithm", name)
+		}
+	}
+}
+
+func TestSelectorOptions(t *testing.T) {
+	opts := SelectorOptions{"replicas": "160", "factor": "1.25", "ttl": "5m", "bad": "five"}
+
+	if replicas, err := opts.Int("replicas", 10); err != nil || replicas != 160 {
+		t.Errorf("expected 160 replicas, got %d (%v)", replicas, err)
+	}
+
+	if factor, err := opts.Float("factor", 1); err != nil || factor != 1.25 {
+		t.Errorf("expected a factor of 1.25, got %f (%v)", factor, err)
+	}
+
+	if ttl, err := opts.Duration("ttl", 0); err != nil || ttl.Minutes() != 5 {
+		t.Errorf("expected a ttl of 5m, got %s (%v)", ttl, err)
+	}
+
+	if fallback, err := opts.Int("missing", 42); err != nil || fallback != 42 {
+		t.Errorf("expected the fallback 42, got %d (%v)", fallback, err)
+	}
+
+	if _, err := opts.Int("bad", 0); err == nil {
+		t.Error("expected an error for an invalid integer")
+	}
+}
@@ -0,0 +1,53 @@
+package services
+
+import "math"
+
+// RendezvousSelector implements weighted rendezvous (highest random weight) hashing, every alive service is
+// scored against the key and the highest score wins. Scores use the logarithmic method, -weight / ln(hash),
+// so each service wins a share of the keys proportional to its weight. A service going down only remaps the
+// keys it was winning, without any ring or table to tune, at the cost of scoring every service per selection
+type RendezvousSelector struct {
+	services []*Service
+	key      HashKey
+}
+
+func NewRendezvousSelector(services []*Service, key HashKey) *RendezvousSelector {
+	return &RendezvousSelector{
+		services: services,
+		key:      key,
+	}
+}
+
+func (rs *RendezvousSelector) SelectService(req *SelectionRequest) *Service {
+	return rs.SelectServiceByKey(req.Key(rs.key))
+}
+
+// SelectServiceByKey selects the alive service with the highest score for the key
+func (rs *RendezvousSelector) SelectServiceByKey(key string) *Service {
+	var selected *Service
+	var selectedScore float64
+
+	for _, svc := range rs.services {
+		weight := svc.EffectiveWeight()
+		if !svc.Available() || weight <= 0 {
+			continue
+		}
+
+		score := rendezvousScore(key, svc.Address(), weight)
+		if selected == nil || score > selectedScore {
+			selected = svc
+			selectedScore = score
+		}
+	}
+
+	return selected
+}
+
+// rendezvousScore scores a service for the key, the hash is mapped onto the open interval (0, 1) so that
+// its logarithm is always finite and negative
+func rendezvousScore(key string, address string, weight float64) float64 {
+	hash := hashKey(key + "|" + address)
+	unit := (float64(hash>>11) + 0.5) / (1 << 53)
+
+	return -weight / math.Log(unit)
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRendezvousSelectorHonorsWeights(t *testing.T) {
+	services := newTestServices(3)
+	services[0].Weight = 1
+	services[1].Weight = 2
+	services[2].Weight = 5
+
+	selector := NewRendezvousSelector(services, HashKeySourceIP)
+
+	counts := map[*Service]int{}
+	for i := 0; i < 16000; i++ {
+		counts[selector.SelectServiceByKey(fmt.Sprintf("client-%d", i))]++
+	}
+
+	expected := []int{2000, 4000, 10000}
+	for i, service := range services {
+		if diff := counts[service] - expected[i]; diff < -400 || diff > 400 {
+			t.Errorf("expected about %d keys for %s, got %d", expected[i], service.Name, counts[service])
+		}
+	}
+}
+
+func TestRendezvousSelectorOnlyRemapsFailedService(t *testing.T) {
+	services := newTestServices(6)
+	selector := NewRendezvousSelector(services, HashKeySourceIP)
+
+	before := map[string]*Service{}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("client-%d", i)
+		before[key] = selector.SelectServiceByKey(key)
+	}
+
+	down := services[4]
+	down.FSM.CurrentState = StateDown
+
+	for key, previous := range before {
+		current := selector.SelectServiceByKey(key)
+		if current == down {
+			t.Fatalf("key %s was sent to a service which is down", key)
+		}
+
+		if previous != down && current != previous {
+			t.Errorf("key %s moved from %s to %s although its service is alive", key, previous.Name, current.Name)
+		}
+	}
+}
+
+func TestSelectionRequestKeys(t *testing.T) {
+	req := newTestRequest(1)
+	req.Metadata.SNI = "api.example.com"
+
+	tests := []struct {
+		key      HashKey
+		expected string
+	}{
+		{HashKeySourceIP, "10.0.0.1"},
+		{HashKeySourceIPPort, "10.0.0.1:40001"},
+		{HashKeyFiveTuple, "tcp 10.0.0.1:40001 127.0.0.1:9000"},
+		{HashKeySNI, "api.example.com"},
+		{HashKeyHost, "10.0.0.1"}, // Not sniffed, falls back to the client IP
+	}
+
+	for _, test := range tests {
+		if key := req.Key(test.key); key != test.expected {
+			t.Errorf("expected %s key %q, got %q", test.key, test.expected, key)
+		}
+	}
+}
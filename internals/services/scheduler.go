@@ -0,0 +1,231 @@
+package services
+
+import (
+	"container/heap"
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHealthCheckConcurrency is how many probes a scheduler runs at once unless configured otherwise
+	DefaultHealthCheckConcurrency = 16
+
+	// DefaultHealthCheckJitter is the fraction of its interval a probe is moved by at random
+	DefaultHealthCheckJitter = 0.1
+)
+
+// HealthCheckResult is the outcome of a single health check of a service
+type HealthCheckResult struct {
+	// Time is when the check started
+	Time time.Time
+
+	// Latency is how long the check took
+	Latency time.Duration
+
+	// Err is why the check failed, nil if the service was healthy
+	Err error
+}
+
+// Healthy reports whether the check passed
+func (r HealthCheckResult) Healthy() bool {
+	return r.Err == nil
+}
+
+// probeKind is the kind of check a scheduled probe runs
+type probeKind int
+
+const (
+	probeHealth probeKind = iota
+	probeAgent
+)
+
+// scheduledProbe is a check of a service waiting for its next run
+type scheduledProbe struct {
+	svc   *Service
+	kind  probeKind
+	next  time.Time
+	index int
+}
+
+// interval returns the time between two runs of the probe
+func (p *scheduledProbe) interval() time.Duration {
+	if p.kind == probeAgent {
+		if p.svc.AgentCheck.Interval > 0 {
+			return p.svc.AgentCheck.Interval
+		}
+
+		return defaultAgentCheckInterval
+	}
+
+	if p.svc.HealthCheckDuration > 0 {
+		return p.svc.HealthCheckDuration
+	}
+
+	return defaultHealthCheckDuration
+}
+
+// probeQueue is a min-heap of probes ordered by their next run
+type probeQueue []*scheduledProbe
+
+func (q probeQueue) Len() int           { return len(q) }
+func (q probeQueue) Less(i, j int) bool { return q[i].next.Before(q[j].next) }
+
+func (q probeQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *probeQueue) Push(x any) {
+	probe := x.(*scheduledProbe)
+	probe.index = len(*q)
+	*q = append(*q, probe)
+}
+
+func (q *probeQueue) Pop() any {
+	old := *q
+	probe := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+
+	return probe
+}
+
+// HealthCheckScheduler runs the health and agent checks of every registered service from a single loop.
+// Probes are spread over their interval with jitter so services registered together aren't checked
+// together, at most maxConcurrent probes run at once and a service is never probed twice concurrently
+type HealthCheckScheduler struct {
+	jitter  float64
+	slots   chan struct{}
+	queue   probeQueue
+	results map[*Service]HealthCheckResult
+	wake    chan struct{}
+	running sync.WaitGroup
+	mu      sync.Mutex
+}
+
+// NewHealthCheckScheduler creates a scheduler running at most maxConcurrent probes at once, every run of a
+// probe is moved by up to jitter times its interval
+func NewHealthCheckScheduler(maxConcurrent int, jitter float64) *HealthCheckScheduler {
+	if maxConcurrent <= 0 {
+		maxConcurrent = DefaultHealthCheckConcurrency
+	}
+
+	return &HealthCheckScheduler{
+		jitter:  min(max(jitter, 0), 1),
+		slots:   make(chan struct{}, maxConcurrent),
+		results: make(map[*Service]HealthCheckResult),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Register schedules the checks of the service, immediately lets a PENDING service get its first result
+// right away instead of waiting up to an interval for it. Services can be registered while the scheduler runs
+func (hs *HealthCheckScheduler) Register(svc *Service, immediately bool) {
+	now := time.Now()
+
+	hs.mu.Lock()
+	probes := []*scheduledProbe{{svc: svc, kind: probeHealth}}
+	if svc.AgentCheck != nil {
+		probes = append(probes, &scheduledProbe{svc: svc, kind: probeAgent})
+	}
+
+	for _, probe := range probes {
+		probe.next = now
+		if !immediately {
+			// Spread the first runs over the whole interval, later runs keep their distance
+			probe.next = now.Add(time.Duration(rand.Float64() * float64(probe.interval())))
+		}
+
+		heap.Push(&hs.queue, probe)
+	}
+	hs.mu.Unlock()
+
+	hs.signal()
+}
+
+// LastResult returns the result of the last health check of the service, false if it wasn't checked yet
+func (hs *HealthCheckScheduler) LastResult(svc *Service) (HealthCheckResult, bool) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	result, ok := hs.results[svc]
+	return result, ok
+}
+
+// Run starts probes as they become due until the context is done, then waits for running probes to return
+func (hs *HealthCheckScheduler) Run(ctx context.Context) {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		hs.mu.Lock()
+		wait := time.Hour
+		if len(hs.queue) > 0 {
+			wait = time.Until(hs.queue[0].next)
+		}
+
+		if wait <= 0 {
+			probe := heap.Pop(&hs.queue).(*scheduledProbe)
+			hs.mu.Unlock()
+
+			hs.running.Add(1)
+			go hs.run(ctx, probe)
+			continue
+		}
+		hs.mu.Unlock()
+
+		timer.Reset(wait)
+		select {
+		case <-ctx.Done():
+			hs.running.Wait()
+			return
+		case <-hs.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// run waits for a free slot, runs the probe and schedules its next run
+func (hs *HealthCheckScheduler) run(ctx context.Context, probe *scheduledProbe) {
+	defer hs.running.Done()
+
+	select {
+	case hs.slots <- struct{}{}:
+	case <-ctx.Done():
+		return
+	}
+
+	switch probe.kind {
+	case probeHealth:
+		if result, probed := probe.svc.runHealthCheck(ctx); probed {
+			hs.mu.Lock()
+			hs.results[probe.svc] = result
+			hs.mu.Unlock()
+		}
+	case probeAgent:
+		probe.svc.runAgentCheck(ctx)
+	}
+
+	<-hs.slots
+
+	interval := probe.interval()
+	offset := hs.jitter * (2*rand.Float64() - 1) * float64(interval)
+
+	hs.mu.Lock()
+	probe.next = time.Now().Add(interval + time.Duration(offset))
+	heap.Push(&hs.queue, probe)
+	hs.mu.Unlock()
+
+	hs.signal()
+}
+
+// signal wakes the loop up so it notices probes scheduled sooner than the one it waits for
+func (hs *HealthCheckScheduler) signal() {
+	select {
+	case hs.wake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingHealthChecker takes delay to return err and keeps track of how many checks ran at once
+type countingHealthChecker struct {
+	delay      time.Duration
+	err        error
+	calls      atomic.Int32
+	running    atomic.Int32
+	maxRunning atomic.Int32
+}
+
+func (c *countingHealthChecker) Check(_ context.Context, _ *Service) error {
+	c.calls.Add(1)
+
+	running := c.running.Add(1)
+	defer c.running.Add(-1)
+
+	for {
+		seen := c.maxRunning.Load()
+		if running <= seen || c.maxRunning.CompareAndSwap(seen, running) {
+			break
+		}
+	}
+
+	time.Sleep(c.delay)
+	return c.err
+}
+
+// runTestScheduler runs the scheduler until the test ends
+func runTestScheduler(t *testing.T, scheduler *HealthCheckScheduler) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		scheduler.Run(ctx)
+		close(done)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+// waitFor polls the condition until it holds, failing the test after a second
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// newTestScheduledService creates a PENDING service checked by the checker
+func newTestScheduledService(name string, checker HealthChecker) *Service {
+	service := newTestService(name, StatePending, 1)
+	service.HealthChecker = checker
+	service.HealthCheckTimeout = time.Second
+	service.HealthCheckDuration = time.Hour
+
+	return service
+}
+
+func TestSchedulerChecksImmediately(t *testing.T) {
+	scheduler := NewHealthCheckScheduler(4, DefaultHealthCheckJitter)
+	healthy := newTestScheduledService("healthy", &countingHealthChecker{delay: 5 * time.Millisecond})
+	failing := newTestScheduledService("failing", &countingHealthChecker{err: errors.New("connection refused")})
+
+	scheduler.Register(healthy, true)
+	scheduler.Register(failing, true)
+	runTestScheduler(t, scheduler)
+
+	waitFor(t, func() bool {
+		_, checkedHealthy := scheduler.LastResult(healthy)
+		_, checkedFailing := scheduler.LastResult(failing)
+
+		return checkedHealthy && checkedFailing
+	})
+
+	result, _ := scheduler.LastResult(healthy)
+	if !result.Healthy() || result.Latency < 5*time.Millisecond || result.Time.IsZero() {
+		t.Errorf("expected a healthy result with its latency, got %+v", result)
+	}
+
+	if result, _ := scheduler.LastResult(failing); result.Healthy() {
+		t.Error("expected the error of the failing check to be kept")
+	}
+
+	waitFor(t, func() bool { return healthy.FSM.CurrentState == StateAlive && failing.FSM.CurrentState == StateDown })
+}
+
+func TestSchedulerCapsConcurrentChecks(t *testing.T) {
+	checker := &countingHealthChecker{delay: 20 * time.Millisecond}
+	scheduler := NewHealthCheckScheduler(2, DefaultHealthCheckJitter)
+
+	for i := 0; i < 8; i++ {
+		scheduler.Register(newTestScheduledService(fmt.Sprintf("service-%d", i), checker), true)
+	}
+
+	runTestScheduler(t, scheduler)
+	waitFor(t, func() bool { return checker.calls.Load() == 8 })
+
+	if running := checker.maxRunning.Load(); running > 2 {
+		t.Errorf("expected at most 2 checks at once, got %d", running)
+	}
+}
+
+func TestSchedulerSpreadsFirstChecks(t *testing.T) {
+	scheduler := NewHealthCheckScheduler(1, DefaultHealthCheckJitter)
+	registeredAt := time.Now()
+
+	for i := 0; i < 20; i++ {
+		service := newTestScheduledService(fmt.Sprintf("service-%d", i), &countingHealthChecker{})
+		service.AgentCheck = &AgentChecker{Port: 1, Interval: time.Minute}
+		scheduler.Register(service, false)
+	}
+
+	if len(scheduler.queue) != 40 {
+		t.Fatalf("expected a health and an agent check per service, got %d probes", len(scheduler.queue))
+	}
+
+	distinct := make(map[time.Time]bool)
+	for _, probe := range scheduler.queue {
+		if probe.next.Before(registeredAt) || probe.next.After(registeredAt.Add(probe.interval())) {
+			t.Errorf("expected the first check within an interval, got %s", probe.next.Sub(registeredAt))
+		}
+
+		distinct[probe.next] = true
+	}
+
+	if len(distinct) < 30 {
+		t.Errorf("expected the first checks to be spread, got %d distinct times for 40 probes", len(distinct))
+	}
+}
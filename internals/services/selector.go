@@ -1,7 +1,102 @@
 package services
 
+import "net"
+
+// SelectionMetadata contains routing information sniffed from the first bytes sent by the client
+type SelectionMetadata struct {
+	// SNI is the server name requested in a TLS ClientHello
+	SNI string
+
+	// ALPN contains the application protocols offered in a TLS ClientHello
+	ALPN []string
+
+	// Host is the Host header of a plaintext HTTP request
+	Host string
+}
+
+// SelectionRequest describes the connection for which a service is being selected
+type SelectionRequest struct {
+	// ClientAddr is the address of the client which opened the connection
+	ClientAddr net.Addr
+
+	// LocalAddr is the address of the listener which accepted the connection
+	LocalAddr net.Addr
+
+	// Metadata is empty unless sniffing is enabled and the client sent something recognizable
+	Metadata SelectionMetadata
+}
+
+// ClientIP returns the IP address of the client without its port
+func (r *SelectionRequest) ClientIP() string {
+	if r == nil || r.ClientAddr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(r.ClientAddr.String())
+	if err != nil {
+		return r.ClientAddr.String()
+	}
+
+	return host
+}
+
+// Key returns the key hashing selectors use for this request, requests missing the information needed
+// for the key, such as connections which weren't sniffed, fall back to the IP address of the client
+func (r *SelectionRequest) Key(key HashKey) string {
+	if r == nil {
+		return ""
+	}
+
+	switch key {
+	case HashKeySourceIPPort:
+		if r.ClientAddr != nil {
+			return r.ClientAddr.String()
+		}
+	case HashKeyFiveTuple:
+		if r.ClientAddr != nil && r.LocalAddr != nil {
+			return r.ClientAddr.Network() + " " + r.ClientAddr.String() + " " + r.LocalAddr.String()
+		}
+	case HashKeySNI:
+		if r.Metadata.SNI != "" {
+			return r.Metadata.SNI
+		}
+	case HashKeyHost:
+		if r.Metadata.Host != "" {
+			return r.Metadata.Host
+		}
+	}
+
+	return r.ClientIP()
+}
+
 // BackendSelector implements methods to select an available service
 // based on a certain algorithm
 type BackendSelector interface {
+	SelectService(req *SelectionRequest) *Service
+}
+
+// ServingSelector is implemented by selectors which only send new connections to some of their services at
+// a time, such as the TieredSelector, so selectors pinning clients can let go of services outside of them
+type ServingSelector interface {
+	Serves(svc *Service) bool
+}
+
+// ClientUnawareSelector is implemented by selectors which don't need to know anything about
+// the connection, such as the round-robin selectors
+type ClientUnawareSelector interface {
 	SelectService() *Service
 }
+
+// clientUnawareAdapter adapts a ClientUnawareSelector into a BackendSelector
+type clientUnawareAdapter struct {
+	selector ClientUnawareSelector
+}
+
+// AdaptSelector turns a ClientUnawareSelector into a BackendSelector which ignores the selection request
+func AdaptSelector(selector ClientUnawareSelector) BackendSelector {
+	return &clientUnawareAdapter{selector: selector}
+}
+
+func (a *clientUnawareAdapter) SelectService(_ *SelectionRequest) *Service {
+	return a.selector.SelectService()
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/frostzt/splitbit/internals"
+)
+
+// newTestService creates a service in the given state without a logger or health checking
+func newTestService(name string, state internals.StateType, weight int) *Service {
+	return &Service{
+		Name: name,
+		Host: name,
+		Port: 9990,
+		FSM: &internals.StateMachine{
+			CurrentState: state,
+			States:       NewFSMForService().States,
+		},
+		HealthCheckPath: "/health",
+		Weight:          weight,
+		Logger:          internals.NewLogger(internals.EnvProd),
+		Metadata:        ServiceMetadata{},
+	}
+}
+
+// sendTestEvent sends the event to the FSM of the service and fails the test if it is rejected
+func sendTestEvent(t *testing.T, svc *Service, event internals.EventType) {
+	t.Helper()
+
+	if err := svc.FSM.SendEvent(event, &CommonActionCtx{svc: svc}); err != nil {
+		t.Fatalf("event %s rejected for %s in state %s: %v", event, svc.Name, svc.FSM.CurrentState, err)
+	}
+}
+
+// newTestServices creates count alive services with a weight of 1
+func newTestServices(count int) []*Service {
+	services := make([]*Service, 0, count)
+	for i := 0; i < count; i++ {
+		services = append(services, newTestService(fmt.Sprintf("service-%d", i), StateAlive, 1))
+	}
+
+	return services
+}
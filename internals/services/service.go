@@ -3,7 +3,8 @@ package services
 import (
 	"context"
 	"fmt"
-	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/frostzt/splitbit/internals"
@@ -22,6 +23,10 @@ const (
 	// StateHalfOpen represents a service which has failed health check but is currently being tried again to recover
 	StateHalfOpen internals.StateType = "HALF_OPEN"
 
+	// StateSuppressed represents a service which went down too often recently and is held back until its
+	// flap score decays
+	StateSuppressed internals.StateType = "SUPPRESSED"
+
 	// EventSuccess triggers when a service has passed health check
 	EventSuccess internals.EventType = "SUCCESS"
 
@@ -31,17 +36,56 @@ const (
 	// EventForceRecovery triggers when a service is probed again after it went down in an attempt
 	// to being recovered
 	EventForceRecovery internals.EventType = "RECOVERY"
+
+	// EventSuppress triggers when a service which just went down has been flapping
+	EventSuppress internals.EventType = "SUPPRESS"
+)
+
+const (
+	// defaultHealthCheckDuration is the default time interval used in health checks
+	defaultHealthCheckDuration = 5 * time.Second
+
+	// defaultHealthCheckTimeout is how long a health check may take before it is considered failed
+	defaultHealthCheckTimeout = 3 * time.Second
+
+	// defaultHealthCheckRise is the number of consecutive successful checks bringing a DOWN service back
+	defaultHealthCheckRise = 2
+
+	// defaultHealthCheckFall is the number of consecutive failed checks taking an ALIVE service DOWN
+	defaultHealthCheckFall = 3
+
+	// defaultRecoveryCooldown is how long a DOWN service is left alone before its first recovery attempt
+	defaultRecoveryCooldown = 10 * time.Second
+
+	// defaultMaxRecoveryCooldown caps the cooldown of a service which keeps failing to recover
+	defaultMaxRecoveryCooldown = 5 * time.Minute
 )
 
-// defaultHealthCheckDuration is the default time interval used in health checks
-const defaultHealthCheckDuration = 5 * time.Second
+// slowStartMinFactor is the fraction of its weight a service starts with when slow start begins
+const slowStartMinFactor = 0.1
 
 type ServiceMetadata struct {
-	// FailureCount tracks how many subsequent requests to this service has failed
+	// FailureCount tracks how many subsequent health checks of this service have failed
 	FailureCount int
 
+	// SuccessCount tracks how many subsequent health checks of this service have succeeded
+	SuccessCount int
+
 	// LastRecoveryAttempt is the time at which this service was down and a forced recovery was initiated
 	LastRecoveryAttempt time.Time
+
+	// DownSince is the time at which this service last went DOWN, the cooldown is measured from it
+	DownSince time.Time
+
+	// RecoveryBackoff is the cooldown before the next recovery attempt, it doubles every time the service
+	// fails to recover and starts over once the service is ALIVE again
+	RecoveryBackoff time.Duration
+
+	// AliveSince is the time at which this service last became ALIVE, slow start ramps up from it
+	AliveSince time.Time
+
+	// Agent is what the agent check of this service reported so far
+	Agent AgentReport
 }
 
 // Service corresponds to an Application server listening on the provided host and port
@@ -59,29 +103,109 @@ type Service struct {
 	// FSM is the state machine which keeps track of the current state of this service
 	FSM *internals.StateMachine
 
-	// HealthCheckPath points to the health check path for this service
+	// HealthChecker probes this service to decide whether it can receive traffic
+	HealthChecker HealthChecker
+
+	// HealthCheckPath points to the health check path for this service, it is only used to build an HTTP
+	// health check when HealthChecker is nil
+	//
+	// Deprecated: set HealthChecker to an HTTPHealthChecker instead
 	HealthCheckPath string
 
 	// HealthCheckDuration is the interval in which the proxy will hit the service
 	HealthCheckDuration time.Duration
 
-	// ConnectionCount tracks active count to this service
-	ConnectionCount int
+	// HealthCheckTimeout is how long a single health check may take
+	HealthCheckTimeout time.Duration
+
+	// HealthCheckRise is how many consecutive successful health checks bring the service back from DOWN
+	HealthCheckRise int
+
+	// HealthCheckFall is how many consecutive failed health checks take the service DOWN
+	HealthCheckFall int
+
+	// RecoveryCooldown is how long the service stays DOWN before it is moved to HALF_OPEN and probed again
+	RecoveryCooldown time.Duration
+
+	// MaxRecoveryCooldown caps the exponential backoff of RecoveryCooldown
+	MaxRecoveryCooldown time.Duration
+
+	// ConnectionCount tracks active count to this service, it must only be accessed atomically
+	// through AcquireConnection, ReleaseConnection and ActiveConnections
+	ConnectionCount int64
 
 	// Weight for weighted-load balancing
 	Weight int
 
+	// Zone is the zone or region this service runs in, used to prefer services close to splitbit
+	Zone string
+
+	// SlowStart is how long the effective weight of this service ramps up for after it becomes ALIVE
+	SlowStart time.Duration
+
+	// Priority is the tier of this service, services with a lower priority receive traffic first and the
+	// others only take over when too few of them are alive
+	Priority int
+
 	// Logger directly injected into service
 	Logger *internals.Logger
 
-	// Metadata contains information used by Splitbit to maintain this service
-	Metadata ServiceMetadata
+	// Metadata contains information used by Splitbit to maintain this service, it is guarded by metadataMu
+	// since it is read by selectors while health checks update it
+	Metadata   ServiceMetadata
+	metadataMu sync.RWMutex
+
+	// connectLatency tracks how long it takes to establish connections to this service
+	connectLatency peakEWMA
+
+	// firstByteLatency tracks how long this service takes to send its first byte on a connection
+	firstByteLatency peakEWMA
+
+	// passiveHealth tracks proxied connections to eject this service between active checks, nil if disabled
+	passiveHealth *passiveHealth
+
+	// AgentCheck asks an agent running alongside this service for its weight and state, nil if disabled
+	AgentCheck *AgentChecker
+
+	// flapDamping suppresses this service when it keeps going DOWN, nil if disabled
+	flapDamping *flapDamping
+
+	// transitions are the latest transitions of this service, guarded by metadataMu
+	transitions []Transition
+
+	// stateListeners are notified whenever the FSM of this service transitions
+	stateListeners   []StateListener
+	stateListenersMu sync.RWMutex
 }
 
+// StateListener is notified when a service transitions between states, listeners are called while the FSM
+// of the service is locked so they must not send events to it and should return quickly. Listeners are also
+// notified with from equal to to when the service became available or unavailable without a transition,
+// such as its agent draining it
+type StateListener func(svc *Service, from internals.StateType, to internals.StateType)
+
 type ServiceOptions struct {
-	Name            string
+	Name          string
+	HealthChecker HealthChecker
+
+	// HealthCheckPath builds an HTTP health check when HealthChecker is nil
+	//
+	// Deprecated: set HealthChecker to an HTTPHealthChecker instead
 	HealthCheckPath string
-	Weight          int
+
+	HealthCheckInterval time.Duration
+	HealthCheckTimeout  time.Duration
+	HealthCheckRise     int
+	HealthCheckFall     int
+	RecoveryCooldown    time.Duration
+	MaxRecoveryCooldown time.Duration
+	PassiveHealthCheck  *PassiveHealthOptions
+	AgentCheck          *AgentChecker
+	FlapDamping         *FlapDampingOptions
+	Weight              int
+	Priority            int
+	SlowStart           time.Duration
+	Zone                string
 }
 
 func NewService(host string, port int, opts *ServiceOptions, logger *internals.Logger) *Service {
@@ -90,8 +214,13 @@ func NewService(host string, port int, opts *ServiceOptions, logger *internals.L
 		Host:                host,
 		Port:                port,
 		FSM:                 NewFSMForService(),
-		HealthCheckPath:     "/health",
+		HealthChecker:       &TCPHealthChecker{},
 		HealthCheckDuration: defaultHealthCheckDuration,
+		HealthCheckTimeout:  defaultHealthCheckTimeout,
+		HealthCheckRise:     defaultHealthCheckRise,
+		HealthCheckFall:     defaultHealthCheckFall,
+		RecoveryCooldown:    defaultRecoveryCooldown,
+		MaxRecoveryCooldown: defaultMaxRecoveryCooldown,
 		Weight:              0,
 		Logger:              logger,
 		Metadata: ServiceMetadata{
@@ -104,13 +233,60 @@ func NewService(host string, port int, opts *ServiceOptions, logger *internals.L
 			s.Name = opts.Name
 		}
 
-		if opts.HealthCheckPath != "" {
+		if opts.HealthChecker != nil {
+			s.HealthChecker = opts.HealthChecker
+		} else if opts.HealthCheckPath != "" {
 			s.HealthCheckPath = opts.HealthCheckPath
+			s.HealthChecker = NewHTTPHealthChecker(opts.HealthCheckPath, nil)
+		}
+
+		if opts.HealthCheckInterval > 0 {
+			s.HealthCheckDuration = opts.HealthCheckInterval
+		}
+
+		if opts.HealthCheckTimeout > 0 {
+			s.HealthCheckTimeout = opts.HealthCheckTimeout
+		}
+
+		if opts.HealthCheckRise > 0 {
+			s.HealthCheckRise = opts.HealthCheckRise
+		}
+
+		if opts.HealthCheckFall > 0 {
+			s.HealthCheckFall = opts.HealthCheckFall
+		}
+
+		if opts.RecoveryCooldown > 0 {
+			s.RecoveryCooldown = opts.RecoveryCooldown
+		}
+
+		if opts.MaxRecoveryCooldown > 0 {
+			s.MaxRecoveryCooldown = opts.MaxRecoveryCooldown
+		}
+
+		if opts.PassiveHealthCheck != nil {
+			s.passiveHealth = newPassiveHealth(*opts.PassiveHealthCheck)
+		}
+
+		s.AgentCheck = opts.AgentCheck
+
+		if opts.FlapDamping != nil {
+			s.flapDamping = newFlapDamping(*opts.FlapDamping)
 		}
 
 		if opts.Weight > 0 {
 			s.Weight = opts.Weight
 		}
+
+		if opts.Priority > 0 {
+			s.Priority = opts.Priority
+		}
+
+		if opts.SlowStart > 0 {
+			s.SlowStart = opts.SlowStart
+		}
+
+		s.Zone = opts.Zone
 	}
 
 	return s
@@ -137,9 +313,17 @@ func NewFSMForService() *internals.StateMachine {
 				Events: internals.Events{
 					EventSuccess:       StateAlive,
 					EventForceRecovery: StateHalfOpen,
+					EventSuppress:      StateSuppressed,
+				},
+			},
+			StateSuppressed: internals.State{
+				Action: &ServiceSuppressedAction{},
+				Events: internals.Events{
+					EventForceRecovery: StateHalfOpen,
 				},
 			},
 			StateHalfOpen: internals.State{
+				Action: &ServiceHalfOpenAction{},
 				Events: internals.Events{
 					EventFailure: StateDown,
 					EventSuccess: StateAlive,
@@ -149,60 +333,252 @@ func NewFSMForService() *internals.StateMachine {
 	}
 }
 
-// HealthCheckService performs health check on the provided service's health check route if the call fails
-// it marks the [AliveStatus] as false otherwise marks it as true
-func (s *Service) HealthCheckService() error {
-	url := fmt.Sprintf("http://%s:%d%s", s.Host, s.Port, s.HealthCheckPath)
+// HealthCheckService probes the service with its configured HealthChecker, giving up after the
+// health check timeout. Services built without a HealthChecker are checked over HTTP on HealthCheckPath
+func (s *Service) HealthCheckService(ctx context.Context) error {
+	timeout := s.HealthCheckTimeout
+	if timeout <= 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// The checker is kept so its connection is reused, a service is never checked twice at once
+	if s.HealthChecker == nil {
+		s.HealthChecker = NewHTTPHealthChecker(s.HealthCheckPath, nil)
+	}
+
+	return s.HealthChecker.Check(ctx, s)
+}
+
+// runHealthCheck probes the service once and sends the resulting event to its state machine, if any.
+// A DOWN service acts as an open circuit breaker, it isn't probed at all until its cooldown is over and
+// it is moved to HALF_OPEN, in which case false is returned without a result. A SUPPRESSED service is
+// moved to HALF_OPEN once its flap score decayed, without waiting for another cooldown
+func (s *Service) runHealthCheck(ctx context.Context) (HealthCheckResult, bool) {
+	if now := time.Now(); s.reuseDue(now) {
+		s.Logger.Info("Service %s stopped flapping, attempting to recover it", s.Name)
+
+		if err := s.FSM.SendEvent(EventForceRecovery, &CommonActionCtx{svc: s}); err != nil {
+			s.Logger.Warn("FSM rejected event %s for service %s, %v", EventForceRecovery, s.Name, err)
+		}
+	} else if s.recoveryDue(now) {
+		s.Logger.Info("Attempting to recover service %s", s.Name)
+
+		if err := s.FSM.SendEvent(EventForceRecovery, &CommonActionCtx{svc: s}); err != nil {
+			s.Logger.Warn("FSM rejected event %s for service %s, %v", EventForceRecovery, s.Name, err)
+		}
+	}
+
+	if s.FSM.CurrentState == StateDown || s.FSM.CurrentState == StateSuppressed {
+		return HealthCheckResult{}, false
+	}
+
+	result := HealthCheckResult{Time: time.Now()}
+	result.Err = s.HealthCheckService(ctx)
+	result.Latency = time.Since(result.Time)
+
+	if result.Err != nil {
+		s.Logger.Error("Health check failed for service %s: %s", s.Name, result.Err)
+	}
+
+	event := s.recordHealthCheck(result.Err)
+	if event == internals.NOOP {
+		return result, true
+	}
+
+	// Update the state
+	if err := s.FSM.SendEvent(event, &CommonActionCtx{svc: s}); err != nil {
+		s.Logger.Warn("FSM rejected event %s for service %s, %v", event, s.Name, err)
+	}
+
+	return result, true
+}
+
+// recordHealthCheck counts the result of a health check and returns the event the state machine should
+// receive, which is NOOP until the result has been seen HealthCheckRise or HealthCheckFall times in a row.
+// A PENDING service has no state to protect so its first result decides its state right away, and a
+// HALF_OPEN service is on trial so a single failure takes it back DOWN
+func (s *Service) recordHealthCheck(err error) internals.EventType {
+	s.metadataMu.Lock()
+	defer s.metadataMu.Unlock()
 
-	client := &http.Client{Timeout: 3 * time.Second}
-	resp, err := client.Get(url)
 	if err != nil {
-		return err
+		s.Metadata.FailureCount++
+		s.Metadata.SuccessCount = 0
+	} else {
+		s.Metadata.SuccessCount++
+		s.Metadata.FailureCount = 0
+	}
+
+	switch s.FSM.CurrentState {
+	case StatePending:
+		if err != nil {
+			return EventFailure
+		}
+
+		return EventSuccess
+	case StateAlive:
+		if err != nil && s.Metadata.FailureCount >= s.HealthCheckFall {
+			s.Logger.Warn("Service %s has failed %d consecutive health checks", s.Name, s.Metadata.FailureCount)
+			return EventFailure
+		}
+	case StateHalfOpen:
+		if err != nil {
+			s.Logger.Warn("Service %s failed to recover, retrying in %s", s.Name, s.nextRecoveryBackoff())
+			return EventFailure
+		}
+
+		if s.Metadata.SuccessCount >= s.HealthCheckRise {
+			return EventSuccess
+		}
 	}
-	defer func() { _ = resp.Body.Close() }()
 
-	// Verify that the health check returned a 200 code
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("non-200 health check %d", resp.StatusCode)
+	return internals.NOOP
+}
+
+// recoveryDue reports whether the service is DOWN and its cooldown is over at now
+func (s *Service) recoveryDue(now time.Time) bool {
+	if s.FSM.CurrentState != StateDown {
+		return false
 	}
 
-	return nil
+	s.metadataMu.RLock()
+	defer s.metadataMu.RUnlock()
+
+	return !now.Before(s.Metadata.DownSince.Add(s.Metadata.RecoveryBackoff))
 }
 
-// PeriodicallyHealthCheckService will run health check onto every registered service every 5 seconds
-// if the service fails the health check the service will be marked as `AliveStatus = false`
-func (s *Service) PeriodicallyHealthCheckService(ctx context.Context) {
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
+// nextRecoveryBackoff returns the cooldown applied if the current recovery attempt fails, metadataMu
+// must be held by the caller
+func (s *Service) nextRecoveryBackoff() time.Duration {
+	if s.Metadata.RecoveryBackoff <= 0 {
+		return s.RecoveryCooldown
+	}
+
+	return min(2*s.Metadata.RecoveryBackoff, max(s.MaxRecoveryCooldown, s.RecoveryCooldown))
+}
+
+// OnStateChange registers a listener notified on every state transition of this service
+func (s *Service) OnStateChange(listener StateListener) {
+	s.stateListenersMu.Lock()
+	defer s.stateListenersMu.Unlock()
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			err := s.HealthCheckService()
-			event := EventSuccess
-			if err != nil {
-				event = EventFailure
-				s.Logger.Error("Health check failed for service %s: %s", s.Name, err)
-			}
+	s.stateListeners = append(s.stateListeners, listener)
+}
+
+// notifyStateChange records the transition the FSM just went through and notifies every listener of it
+func (s *Service) notifyStateChange() {
+	s.recordTransition()
 
-			// Do not resend Success events to state machine if the current state is already ALIVE
-			if s.FSM.CurrentState == StateAlive && event == EventSuccess {
-				continue
-			}
+	s.stateListenersMu.RLock()
+	defer s.stateListenersMu.RUnlock()
+
+	for _, listener := range s.stateListeners {
+		listener(s, s.FSM.PreviousState, s.FSM.CurrentState)
+	}
+}
 
-			// Do not resend Failure events to state machine if the current state is already DOWN
-			if s.FSM.CurrentState == StateDown && event == EventFailure {
-				continue
-			}
+// notifyAvailabilityChange notifies every listener that the service became available or unavailable while
+// staying in its current state, the FSM is locked like it is for transitions so listeners see a stable state
+func (s *Service) notifyAvailabilityChange() {
+	s.FSM.Locked(func() {
+		s.stateListenersMu.RLock()
+		defer s.stateListenersMu.RUnlock()
 
-			// Update the state
-			if err := s.FSM.SendEvent(event, &CommonActionCtx{svc: s}); err != nil {
-				s.Logger.Warn("FSM rejected event %s for service %s, %v", event, s.Name, err)
-			}
+		for _, listener := range s.stateListeners {
+			listener(s, s.FSM.CurrentState, s.FSM.CurrentState)
 		}
+	})
+}
+
+// Available reports whether the service can receive new connections, which requires it to be ALIVE and
+// its agent, if any, not to have drained it, put it in maintenance or reported it as down
+func (s *Service) Available() bool {
+	if s.FSM.CurrentState != StateAlive {
+		return false
+	}
+
+	s.metadataMu.RLock()
+	defer s.metadataMu.RUnlock()
+
+	return s.Metadata.Agent.acceptsConnections()
+}
+
+// acceptsPersistentConnections reports whether clients already pinned to the service may keep using it,
+// unlike new clients they are still accepted while the service is being drained
+func (s *Service) acceptsPersistentConnections() bool {
+	if s.FSM.CurrentState != StateAlive {
+		return false
+	}
+
+	s.metadataMu.RLock()
+	defer s.metadataMu.RUnlock()
+
+	return !s.Metadata.Agent.Down && !s.Metadata.Agent.Maint
+}
+
+// AcquireConnection marks a new connection being proxied to this service
+func (s *Service) AcquireConnection() {
+	atomic.AddInt64(&s.ConnectionCount, 1)
+}
+
+// ReleaseConnection marks a connection to this service as closed
+func (s *Service) ReleaseConnection() {
+	atomic.AddInt64(&s.ConnectionCount, -1)
+}
+
+// ActiveConnections returns the number of connections currently being proxied to this service
+func (s *Service) ActiveConnections() int64 {
+	return atomic.LoadInt64(&s.ConnectionCount)
+}
+
+// EffectiveWeight returns the weight selectors should use for this service, services registered
+// without a weight are treated as having a weight of 1. During slow start the weight ramps up linearly
+// from a fraction of the configured weight, and an agent reporting a percentage scales it further
+func (s *Service) EffectiveWeight() float64 {
+	return s.baseWeight() * s.slowStartFactor(time.Now()) * s.agentWeightFactor()
+}
+
+// agentWeightFactor returns the fraction of its weight the agent of this service asked for, defaulting to 1
+func (s *Service) agentWeightFactor() float64 {
+	s.metadataMu.RLock()
+	defer s.metadataMu.RUnlock()
+
+	if !s.Metadata.Agent.WeightReported {
+		return 1
+	}
+
+	return float64(s.Metadata.Agent.WeightPercent) / 100
+}
+
+// baseWeight returns the configured weight of this service, defaulting to 1
+func (s *Service) baseWeight() float64 {
+	if s.Weight <= 0 {
+		return 1
 	}
+
+	return float64(s.Weight)
+}
+
+// slowStartFactor returns the fraction of its weight this service receives at now
+func (s *Service) slowStartFactor(now time.Time) float64 {
+	if s.SlowStart <= 0 {
+		return 1
+	}
+
+	s.metadataMu.RLock()
+	aliveSince := s.Metadata.AliveSince
+	s.metadataMu.RUnlock()
+
+	elapsed := now.Sub(aliveSince)
+	if aliveSince.IsZero() || elapsed >= s.SlowStart {
+		return 1
+	}
+
+	progress := float64(max(elapsed, 0)) / float64(s.SlowStart)
+	return slowStartMinFactor + (1-slowStartMinFactor)*progress
 }
 
 // Address returns the network address in the host:port form
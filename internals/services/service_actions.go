@@ -17,8 +17,17 @@ func (a *ServiceAliveAction) Execute(eventCtx internals.EventContext) internals.
 
 	ctx.svc.Logger.Debug("Received service alive event for %s", ctx.svc.Name)
 
+	ctx.svc.metadataMu.Lock()
+
 	// Reset the failure count
 	ctx.svc.Metadata.FailureCount = 0
+
+	// Slow start ramps the weight of the service up from now
+	ctx.svc.Metadata.AliveSince = time.Now()
+
+	ctx.svc.metadataMu.Unlock()
+
+	ctx.svc.notifyStateChange()
 	return internals.NOOP
 }
 
@@ -27,11 +36,30 @@ type ServiceDownAction struct{}
 func (a *ServiceDownAction) Execute(eventCtx internals.EventContext) internals.EventType {
 	ctx := eventCtx.(*CommonActionCtx)
 	ctx.svc.Logger.Debug("Received service down event for %s", ctx.svc.Name)
-	ctx.svc.Metadata.FailureCount++
 
-	if ctx.svc.Metadata.FailureCount > 3 {
-		ctx.svc.Logger.Warn("Service %s has failed for 3 consecutive health checks", ctx.svc.Name)
-		return internals.NOOP
+	now := time.Now()
+	ctx.svc.metadataMu.Lock()
+
+	// Reset the success count, the service has to pass HealthCheckRise checks from now on to come back
+	ctx.svc.Metadata.SuccessCount = 0
+
+	// The cooldown doubles every time a recovery attempt fails and starts over otherwise
+	ctx.svc.Metadata.DownSince = now
+	if ctx.svc.FSM.PreviousState == StateHalfOpen {
+		ctx.svc.Metadata.RecoveryBackoff = ctx.svc.nextRecoveryBackoff()
+	} else {
+		ctx.svc.Metadata.RecoveryBackoff = ctx.svc.RecoveryCooldown
+	}
+
+	// Only a service which was serving traffic flaps, failed recovery attempts are handled by the cooldown
+	flapping := ctx.svc.FSM.PreviousState == StateAlive && ctx.svc.countFlap(now)
+
+	ctx.svc.metadataMu.Unlock()
+
+	ctx.svc.notifyStateChange()
+
+	if flapping {
+		return EventSuppress
 	}
 
 	return internals.NOOP
@@ -43,7 +71,30 @@ func (a *ServiceHalfOpenAction) Execute(eventCtx internals.EventContext) interna
 	ctx := eventCtx.(*CommonActionCtx)
 	ctx.svc.Logger.Debug("Received service half open event for %s", ctx.svc.Name)
 
+	ctx.svc.metadataMu.Lock()
 	ctx.svc.Metadata.LastRecoveryAttempt = time.Now()
 
+	// The service is on trial, it has to pass HealthCheckRise checks in a row to close the breaker
+	ctx.svc.Metadata.SuccessCount = 0
+	ctx.svc.Metadata.FailureCount = 0
+	ctx.svc.metadataMu.Unlock()
+
+	ctx.svc.notifyStateChange()
+	return internals.NOOP
+}
+
+type ServiceSuppressedAction struct{}
+
+func (a *ServiceSuppressedAction) Execute(eventCtx internals.EventContext) internals.EventType {
+	ctx := eventCtx.(*CommonActionCtx)
+
+	ctx.svc.metadataMu.RLock()
+	score := ctx.svc.flapDamping.current(time.Now())
+	reuse := ctx.svc.flapDamping.opts.ReuseThreshold
+	ctx.svc.metadataMu.RUnlock()
+
+	ctx.svc.Logger.Warn("Service %s is flapping with a score of %.2f, suppressing it until the score decays below %.2f", ctx.svc.Name, score, reuse)
+
+	ctx.svc.notifyStateChange()
 	return internals.NOOP
 }
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSlowStartRampsEffectiveWeight(t *testing.T) {
+	service := newTestService("slow", StatePending, 10)
+	service.SlowStart = 10 * time.Second
+
+	sendTestEvent(t, service, EventSuccess)
+	aliveSince := service.Metadata.AliveSince
+
+	tests := []struct {
+		elapsed  time.Duration
+		expected float64
+	}{
+		{0, 0.1},
+		{5 * time.Second, 0.55},
+		{10 * time.Second, 1},
+		{time.Minute, 1},
+	}
+
+	for _, test := range tests {
+		if factor := service.slowStartFactor(aliveSince.Add(test.elapsed)); math.Abs(factor-test.expected) > 1e-9 {
+			t.Errorf("expected a factor of %.2f after %s, got %.2f", test.expected, test.elapsed, factor)
+		}
+	}
+
+	if weight := service.EffectiveWeight(); weight > 2 {
+		t.Errorf("expected the effective weight to start low, got %.2f", weight)
+	}
+}
+
+func TestSlowStartRestartsAfterRecovery(t *testing.T) {
+	service := newTestService("slow", StatePending, 4)
+	service.SlowStart = time.Minute
+
+	sendTestEvent(t, service, EventSuccess)
+	service.metadataMu.Lock()
+	service.Metadata.AliveSince = time.Now().Add(-time.Hour)
+	service.metadataMu.Unlock()
+
+	if weight := service.EffectiveWeight(); weight != 4 {
+		t.Fatalf("expected the full weight once slow start is over, got %.2f", weight)
+	}
+
+	sendTestEvent(t, service, EventFailure)
+	sendTestEvent(t, service, EventSuccess)
+
+	if weight := service.EffectiveWeight(); weight > 1 {
+		t.Errorf("expected slow start to begin again after recovering, got %.2f", weight)
+	}
+}
+
+func TestEffectiveWeightWithoutSlowStart(t *testing.T) {
+	service := newTestService("fast", StatePending, 0)
+	sendTestEvent(t, service, EventSuccess)
+
+	if weight := service.EffectiveWeight(); weight != 1 {
+		t.Errorf("expected a service without a weight to default to 1, got %.2f", weight)
+	}
+}
+
+// stubHealthChecker returns a preset result for every check and counts how often it was called
+type stubHealthChecker struct {
+	err   error
+	calls int
+}
+
+func (c *stubHealthChecker) Check(_ context.Context, _ *Service) error {
+	c.calls++
+	return c.err
+}
+
+func TestHealthCheckRiseAndFall(t *testing.T) {
+	checker := &stubHealthChecker{}
+	service := newTestService("checked", StatePending, 1)
+	service.HealthChecker = checker
+	service.HealthCheckTimeout = time.Second
+	service.HealthCheckRise = 2
+	service.HealthCheckFall = 3
+
+	// The first result of a PENDING service decides its state right away
+	service.runHealthCheck(context.Background())
+	if service.FSM.CurrentState != StateAlive {
+		t.Fatalf("expected the service to be ALIVE after its first check, got %s", service.FSM.CurrentState)
+	}
+
+	// A single blip doesn't take the service out of rotation
+	checker.err = errors.New("connection refused")
+	for i := 1; i <= 3; i++ {
+		service.runHealthCheck(context.Background())
+
+		expected := StateAlive
+		if i == 3 {
+			expected = StateDown
+		}
+
+		if service.FSM.CurrentState != expected {
+			t.Fatalf("expected %s after %d failed checks, got %s", expected, i, service.FSM.CurrentState)
+		}
+	}
+
+	// Without a cooldown the next check is a recovery attempt right away
+	checker.err = nil
+	service.runHealthCheck(context.Background())
+	if service.FSM.CurrentState != StateHalfOpen {
+		t.Fatalf("expected the service to be HALF_OPEN after a single success, got %s", service.FSM.CurrentState)
+	}
+
+	service.runHealthCheck(context.Background())
+	if service.FSM.CurrentState != StateAlive {
+		t.Fatalf("expected the service to be ALIVE after 2 successes, got %s", service.FSM.CurrentState)
+	}
+}
+
+func TestHealthCheckFailureStreakIsReset(t *testing.T) {
+	checker := &stubHealthChecker{}
+	service := newTestService("checked", StateAlive, 1)
+	service.HealthChecker = checker
+	service.HealthCheckTimeout = time.Second
+	service.HealthCheckFall = 2
+
+	for i := 0; i < 5; i++ {
+		checker.err = errors.New("timeout")
+		service.runHealthCheck(context.Background())
+
+		checker.err = nil
+		service.runHealthCheck(context.Background())
+	}
+
+	if service.FSM.CurrentState != StateAlive {
+		t.Errorf("expected alternating results to keep the service ALIVE, got %s", service.FSM.CurrentState)
+	}
+}
+
+func TestCircuitBreakerBacksOff(t *testing.T) {
+	checker := &stubHealthChecker{err: errors.New("connection refused")}
+	service := newTestService("breaker", StateAlive, 1)
+	service.HealthChecker = checker
+	service.HealthCheckTimeout = time.Second
+	service.HealthCheckRise = 2
+	service.HealthCheckFall = 1
+	service.RecoveryCooldown = time.Minute
+	service.MaxRecoveryCooldown = 3 * time.Minute
+
+	service.runHealthCheck(context.Background())
+	if service.FSM.CurrentState != StateDown || service.Metadata.RecoveryBackoff != time.Minute {
+		t.Fatalf("expected the service to be DOWN with a cooldown of 1m, got %s and %s", service.FSM.CurrentState, service.Metadata.RecoveryBackoff)
+	}
+
+	// The breaker is open, the service isn't even probed until the cooldown is over
+	service.runHealthCheck(context.Background())
+	if checker.calls != 1 || service.FSM.CurrentState != StateDown {
+		t.Fatalf("expected no probe during the cooldown, got %d probes and state %s", checker.calls, service.FSM.CurrentState)
+	}
+
+	// Every failed recovery attempt doubles the cooldown up to the maximum
+	for _, expected := range []time.Duration{2 * time.Minute, 3 * time.Minute, 3 * time.Minute} {
+		service.Metadata.DownSince = time.Now().Add(-service.Metadata.RecoveryBackoff)
+
+		service.runHealthCheck(context.Background())
+		if service.FSM.CurrentState != StateDown || service.FSM.PreviousState != StateHalfOpen {
+			t.Fatalf("expected a failed recovery attempt to reopen the breaker, got %s", service.FSM.CurrentState)
+		}
+
+		if service.Metadata.RecoveryBackoff != expected {
+			t.Fatalf("expected a cooldown of %s, got %s", expected, service.Metadata.RecoveryBackoff)
+		}
+	}
+
+	if service.Metadata.LastRecoveryAttempt.IsZero() {
+		t.Error("expected the recovery attempts to be recorded")
+	}
+
+	// The breaker closes once the service passes HealthCheckRise trial probes
+	checker.err = nil
+	service.Metadata.DownSince = time.Now().Add(-service.Metadata.RecoveryBackoff)
+
+	service.runHealthCheck(context.Background())
+	if service.FSM.CurrentState != StateHalfOpen {
+		t.Fatalf("expected the service to stay HALF_OPEN after a single trial, got %s", service.FSM.CurrentState)
+	}
+
+	service.runHealthCheck(context.Background())
+	if service.FSM.CurrentState != StateAlive {
+		t.Fatalf("expected the service to be ALIVE after 2 trials, got %s", service.FSM.CurrentState)
+	}
+
+	// Going down again starts over from the initial cooldown
+	checker.err = errors.New("connection refused")
+	service.runHealthCheck(context.Background())
+	if service.Metadata.RecoveryBackoff != time.Minute {
+		t.Errorf("expected the cooldown to start over, got %s", service.Metadata.RecoveryBackoff)
+	}
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/frostzt/splitbit/internals"
+)
+
+const (
+	// tlsRecordHeaderLength is the length of the header preceding every TLS record
+	tlsRecordHeaderLength = 5
+
+	// tlsHandshakeRecord is the content type of a TLS record carrying a handshake message
+	tlsHandshakeRecord = 0x16
+
+	// maxSniffLength bounds how much of the client's first bytes are buffered, a TLS record never exceeds it
+	maxSniffLength = tlsRecordHeaderLength + 16384
+)
+
+// errClientHelloRead aborts the TLS handshake once the ClientHello has been parsed
+var errClientHelloRead = errors.New("client hello read")
+
+// Sniff reads the first bytes sent on the connection, waiting at most timeout for them, and extracts routing
+// information from a TLS ClientHello or an HTTP request. The bytes read are returned so they can be replayed
+// to the backend, a client which doesn't send anything within the timeout results in empty metadata
+func Sniff(conn net.Conn, timeout time.Duration) (SelectionMetadata, []byte, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return SelectionMetadata{}, nil, err
+	}
+	defer func() { _ = conn.SetReadDeadline(time.Time{}) }()
+
+	data := make([]byte, 0, 1024)
+	buf := make([]byte, 1024)
+	for len(data) < maxSniffLength && needsMoreData(data) {
+		n, err := conn.Read(buf)
+		data = append(data, buf[:n]...)
+		if err != nil {
+			// A client which stopped sending, or already half-closed its side, is proxied with what it sent
+			var netErr net.Error
+			if errors.Is(err, io.EOF) || (errors.As(err, &netErr) && netErr.Timeout()) {
+				break
+			}
+
+			return SelectionMetadata{}, data, err
+		}
+	}
+
+	var metadata SelectionMetadata
+	switch {
+	case len(data) > 0 && data[0] == tlsHandshakeRecord:
+		if hello := parseClientHello(data); hello != nil {
+			metadata.SNI = hello.ServerName
+			metadata.ALPN = hello.SupportedProtos
+		}
+	case internals.IsHTTPRequest(string(data)):
+		if request, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(data))); err == nil {
+			metadata.Host = request.Host
+		}
+	}
+
+	return metadata, data, nil
+}
+
+// needsMoreData reports whether the sniffed bytes may still be the start of an incomplete TLS record
+// or incomplete HTTP request headers
+func needsMoreData(data []byte) bool {
+	if len(data) == 0 {
+		return true
+	}
+
+	if data[0] == tlsHandshakeRecord {
+		if len(data) < tlsRecordHeaderLength {
+			return true
+		}
+
+		recordLength := int(data[3])<<8 | int(data[4])
+		return len(data) < tlsRecordHeaderLength+recordLength
+	}
+
+	if len(data) < 8 || internals.IsHTTPRequest(string(data)) {
+		return !bytes.Contains(data, []byte("\r\n\r\n"))
+	}
+
+	return false
+}
+
+// parseClientHello parses a TLS ClientHello by starting a server handshake over the sniffed bytes
+// and aborting it as soon as the hello has been read
+func parseClientHello(data []byte) *tls.ClientHelloInfo {
+	var hello *tls.ClientHelloInfo
+	config := &tls.Config{
+		GetConfigForClient: func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+			hello = &tls.ClientHelloInfo{
+				ServerName:      info.ServerName,
+				SupportedProtos: append([]string(nil), info.SupportedProtos...),
+			}
+
+			return nil, errClientHelloRead
+		},
+	}
+
+	_ = tls.Server(&sniffedConn{reader: bytes.NewReader(data)}, config).Handshake()
+	return hello
+}
+
+// sniffedConn is a read only connection over sniffed bytes, used to parse them with crypto/tls
+type sniffedConn struct {
+	net.Conn
+	reader *bytes.Reader
+}
+
+func (c *sniffedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func (c *sniffedConn) Write(p []byte) (int, error) {
+	return 0, errors.New("sniffed connections are read only")
+}
+
+func (c *sniffedConn) Close() error {
+	return nil
+}
+
+func (c *sniffedConn) SetDeadline(time.Time) error {
+	return nil
+}
+
+func (c *sniffedConn) SetReadDeadline(time.Time) error {
+	return nil
+}
+
+func (c *sniffedConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSniffTLSClientHello(t *testing.T) {
+	client, server := net.Pipe()
+	defer func() { _ = client.Close() }()
+	defer func() { _ = server.Close() }()
+
+	go func() {
+		_ = tls.Client(client, &tls.Config{ServerName: "api.example.com", NextProtos: []string{"h2", "http/1.1"}}).Handshake()
+	}()
+
+	result, data, err := Sniff(server, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data) == 0 || data[0] != tlsHandshakeRecord {
+		t.Fatalf("expected the sniffed bytes to hold a TLS record, got %d bytes", len(data))
+	}
+
+	if result.SNI != "api.example.com" {
+		t.Errorf("expected SNI api.example.com, got %q", result.SNI)
+	}
+
+	if len(result.ALPN) != 2 || result.ALPN[0] != "h2" {
+		t.Errorf("expected ALPN [h2 http/1.1], got %v", result.ALPN)
+	}
+}
+
+func TestSniffHTTPHost(t *testing.T) {
+	client, server := net.Pipe()
+	defer func() { _ = client.Close() }()
+	defer func() { _ = server.Close() }()
+
+	request := "GET /users HTTP/1.1\r\nHost: users.example.com\r\nAccept: */*\r\n\r\n"
+	go func() { _, _ = client.Write([]byte(request)) }()
+
+	result, data, err := Sniff(server, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != request {
+		t.Errorf("expected the whole request to be sniffed, got %q", string(data))
+	}
+
+	if result.Host != "users.example.com" {
+		t.Errorf("expected host users.example.com, got %q", result.Host)
+	}
+}
+
+func TestSniffHalfClosedClient(t *testing.T) {
+	client, server := net.Pipe()
+	defer func() { _ = server.Close() }()
+
+	// Short requests such as a Redis PING are often followed by closing the write side right away
+	go func() {
+		_, _ = client.Write([]byte("PING\r\n"))
+		_ = client.Close()
+	}()
+
+	_, data, err := Sniff(server, time.Second)
+	if err != nil {
+		t.Fatalf("expected the client closing its side not to fail sniffing, got %v", err)
+	}
+
+	if string(data) != "PING\r\n" {
+		t.Errorf("expected the request to be sniffed, got %q", string(data))
+	}
+}
+
+func TestSniffSilentClient(t *testing.T) {
+	client, server := net.Pipe()
+	defer func() { _ = client.Close() }()
+	defer func() { _ = server.Close() }()
+
+	result, data, err := Sniff(server, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data) != 0 || result.SNI != "" || result.Host != "" {
+		t.Errorf("expected nothing to be sniffed, got %+v with %d bytes", result, len(data))
+	}
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+)
+
+// stickEntry pins a client key to the service it was sent to
+type stickEntry struct {
+	key     string
+	service *Service
+	expires time.Time
+}
+
+// StickTable wraps a selector and pins every client key to the service it was first sent to. Entries expire
+// after ttl without being used and the least recently used entry is evicted once maxSize entries are stored.
+// When a pinned service leaves ALIVE, or the wrapped selector is a ServingSelector which stopped sending traffic
+// to it such as a TieredSelector failing back to its primaries, the client is moved to a newly selected service
+// and is pinned to it
+type StickTable struct {
+	selector BackendSelector
+	key      HashKey
+	ttl      time.Duration
+	maxSize  int
+
+	// entries indexes the elements of lru, which holds *stickEntry values with the most recently used first
+	entries map[string]*list.Element
+	lru     *list.List
+
+	// now is swapped out in tests
+	now func() time.Time
+	mu  sync.Mutex
+}
+
+func NewStickTable(selector BackendSelector, key HashKey, ttl time.Duration, maxSize int) (*StickTable, error) {
+	if ttl <= 0 {
+		return nil, errors.New("stick table ttl must be positive")
+	}
+
+	if maxSize < 1 {
+		return nil, errors.New("stick table max_size must be a positive integer")
+	}
+
+	return &StickTable{
+		selector: selector,
+		key:      key,
+		ttl:      ttl,
+		maxSize:  maxSize,
+		entries:  map[string]*list.Element{},
+		lru:      list.New(),
+		now:      time.Now,
+	}, nil
+}
+
+func (st *StickTable) SelectService(req *SelectionRequest) *Service {
+	key := req.Key(st.key)
+	if svc := st.lookup(key); svc != nil {
+		return svc
+	}
+
+	svc := st.selector.SelectService(req)
+	if svc == nil {
+		return nil
+	}
+
+	st.store(key, svc)
+	return svc
+}
+
+// Len returns the number of entries in the table, including expired entries which haven't been purged yet
+func (st *StickTable) Len() int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return st.lru.Len()
+}
+
+// lookup returns the service the key is pinned to if the entry is still valid, the service alive and still
+// served by the wrapped selector, using an entry extends its expiry
+func (st *StickTable) lookup(key string) *Service {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := st.now()
+	st.purgeExpired(now)
+
+	element, ok := st.entries[key]
+	if !ok {
+		return nil
+	}
+
+	entry := element.Value.(*stickEntry)
+	if !entry.service.acceptsPersistentConnections() || !st.serves(entry.service) {
+		st.remove(element)
+		return nil
+	}
+
+	entry.expires = now.Add(st.ttl)
+	st.lru.MoveToFront(element)
+
+	return entry.service
+}
+
+// serves reports whether the wrapped selector still sends traffic to the service
+func (st *StickTable) serves(svc *Service) bool {
+	serving, ok := st.selector.(ServingSelector)
+	return !ok || serving.Serves(svc)
+}
+
+// store pins the key to the service, evicting the least recently used entries if the table is full
+func (st *StickTable) store(key string, svc *Service) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	entry := &stickEntry{key: key, service: svc, expires: st.now().Add(st.ttl)}
+	if element, ok := st.entries[key]; ok {
+		element.Value = entry
+		st.lru.MoveToFront(element)
+		return
+	}
+
+	st.entries[key] = st.lru.PushFront(entry)
+	for st.lru.Len() > st.maxSize {
+		st.remove(st.lru.Back())
+	}
+}
+
+// purgeExpired removes expired entries, since using an entry extends its expiry the least recently used
+// entries are always the first to expire
+func (st *StickTable) purgeExpired(now time.Time) {
+	for element := st.lru.Back(); element != nil; element = st.lru.Back() {
+		if element.Value.(*stickEntry).expires.After(now) {
+			return
+		}
+
+		st.remove(element)
+	}
+}
+
+// remove deletes the element from the table
+func (st *StickTable) remove(element *list.Element) {
+	delete(st.entries, element.Value.(*stickEntry).key)
+	st.lru.Remove(element)
+}
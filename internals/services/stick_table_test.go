@@ -0,0 +1,113 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestStickTable(t *testing.T, services []*Service, maxSize int) (*StickTable, *time.Time) {
+	t.Helper()
+
+	table, err := NewStickTable(AdaptSelector(NewRoundRobinSelector(services)), HashKeySourceIP, time.Minute, maxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	table.now = func() time.Time { return now }
+
+	return table, &now
+}
+
+func TestStickTablePinsClients(t *testing.T) {
+	table, _ := newTestStickTable(t, newTestServices(3), 10)
+
+	first := table.SelectService(newTestRequest(1))
+	for i := 0; i < 10; i++ {
+		if service := table.SelectService(newTestRequest(1)); service != first {
+			t.Fatalf("expected the client to stay on %s, got %s", first.Name, service.Name)
+		}
+	}
+
+	if other := table.SelectService(newTestRequest(2)); other == first {
+		t.Errorf("expected a new client to be balanced by the wrapped selector, got %s again", other.Name)
+	}
+}
+
+func TestStickTableExpiresEntries(t *testing.T) {
+	table, now := newTestStickTable(t, newTestServices(3), 10)
+
+	first := table.SelectService(newTestRequest(1))
+
+	// Using the entry extends its expiry
+	*now = now.Add(50 * time.Second)
+	if service := table.SelectService(newTestRequest(1)); service != first {
+		t.Fatalf("expected the client to stay on %s, got %s", first.Name, service.Name)
+	}
+
+	*now = now.Add(61 * time.Second)
+	table.SelectService(newTestRequest(2))
+	if table.Len() != 1 {
+		t.Errorf("expected the expired entry to be purged, %d entries left", table.Len())
+	}
+}
+
+func TestStickTableEvictsLeastRecentlyUsed(t *testing.T) {
+	table, _ := newTestStickTable(t, newTestServices(3), 2)
+
+	table.SelectService(newTestRequest(1))
+	table.SelectService(newTestRequest(2))
+	table.SelectService(newTestRequest(1))
+	table.SelectService(newTestRequest(3))
+
+	if table.Len() != 2 {
+		t.Fatalf("expected the table to be capped at 2 entries, got %d", table.Len())
+	}
+
+	if _, ok := table.entries["10.0.0.2"]; ok {
+		t.Error("expected the least recently used client to be evicted")
+	}
+
+	if _, ok := table.entries["10.0.0.1"]; !ok {
+		t.Error("expected the recently used client to be kept")
+	}
+}
+
+func TestStickTableFailsOver(t *testing.T) {
+	table, _ := newTestStickTable(t, newTestServices(3), 10)
+
+	first := table.SelectService(newTestRequest(1))
+	first.FSM.CurrentState = StateDown
+
+	second := table.SelectService(newTestRequest(1))
+	if second == nil || second == first {
+		t.Fatalf("expected the client to fail over from %s, got %v", first.Name, second)
+	}
+
+	// The client is pinned to its new service, even once the old one recovers
+	first.FSM.CurrentState = StateAlive
+	if service := table.SelectService(newTestRequest(1)); service != second {
+		t.Errorf("expected the client to stay on %s, got %s", second.Name, service.Name)
+	}
+}
+
+func TestStickTableFailsBackToPrimaries(t *testing.T) {
+	services := newTestServices(2)
+	services[1].Priority = 1
+
+	table, err := NewStickTable(newTestTieredSelector(t, services, 1), HashKeySourceIP, time.Minute, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	services[0].FSM.CurrentState = StateDown
+	if service := table.SelectService(newTestRequest(1)); service != services[1] {
+		t.Fatalf("expected the client to be sent to the backup, got %v", service)
+	}
+
+	// Once the primary recovers the client isn't kept on the backup tier
+	services[0].FSM.CurrentState = StateAlive
+	if service := table.SelectService(newTestRequest(1)); service != services[0] {
+		t.Errorf("expected the client to fail back to the primary, got %s", service.Name)
+	}
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"errors"
+	"slices"
+)
+
+// SelectorBuilder builds the selector used for a subset of the services, wrapping selectors such as the
+// TieredSelector use it to build one selector per group of services
+type SelectorBuilder func(services []*Service) (BackendSelector, error)
+
+// serviceTier groups the services sharing a priority
+type serviceTier struct {
+	priority int
+	services []*Service
+	selector BackendSelector
+}
+
+// aliveCount returns the number of services in the tier which are alive
+func (t *serviceTier) aliveCount() int {
+	count := 0
+	for _, svc := range t.services {
+		if svc.Available() {
+			count++
+		}
+	}
+
+	return count
+}
+
+// TieredSelector groups services into tiers by priority and only sends traffic to the tier with the lowest
+// priority which has at least minHealthy alive services. Backup tiers only receive traffic while every tier
+// before them is below the threshold, and traffic returns to the primaries as soon as they recover
+type TieredSelector struct {
+	tiers      []*serviceTier
+	minHealthy int
+}
+
+func NewTieredSelector(services []*Service, minHealthy int, build SelectorBuilder) (*TieredSelector, error) {
+	if minHealthy < 1 {
+		return nil, errors.New("min_healthy must be a positive integer")
+	}
+
+	byPriority := map[int]*serviceTier{}
+	for _, svc := range services {
+		tier, ok := byPriority[svc.Priority]
+		if !ok {
+			tier = &serviceTier{priority: svc.Priority}
+			byPriority[svc.Priority] = tier
+		}
+
+		tier.services = append(tier.services, svc)
+	}
+
+	tiers := make([]*serviceTier, 0, len(byPriority))
+	for _, tier := range byPriority {
+		selector, err := build(tier.services)
+		if err != nil {
+			return nil, err
+		}
+
+		tier.selector = selector
+		tiers = append(tiers, tier)
+	}
+
+	slices.SortFunc(tiers, func(a, b *serviceTier) int { return a.priority - b.priority })
+
+	return &TieredSelector{
+		tiers:      tiers,
+		minHealthy: minHealthy,
+	}, nil
+}
+
+// Serves reports whether the service is in the tier receiving traffic, every service is while every tier is
+// below the threshold
+func (ts *TieredSelector) Serves(svc *Service) bool {
+	for _, tier := range ts.tiers {
+		if tier.aliveCount() >= ts.minHealthy {
+			return slices.Contains(tier.services, svc)
+		}
+	}
+
+	return true
+}
+
+func (ts *TieredSelector) SelectService(req *SelectionRequest) *Service {
+	for _, tier := range ts.tiers {
+		if tier.aliveCount() < ts.minHealthy {
+			continue
+		}
+
+		if svc := tier.selector.SelectService(req); svc != nil {
+			return svc
+		}
+	}
+
+	// Every tier is below the threshold, a degraded tier is still better than refusing the connection
+	for _, tier := range ts.tiers {
+		if svc := tier.selector.SelectService(req); svc != nil {
+			return svc
+		}
+	}
+
+	return nil
+}
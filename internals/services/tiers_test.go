@@ -0,0 +1,70 @@
+package services
+
+import "testing"
+
+func newTestTieredSelector(t *testing.T, services []*Service, minHealthy int) *TieredSelector {
+	t.Helper()
+
+	selector, err := NewTieredSelector(services, minHealthy, func(tierServices []*Service) (BackendSelector, error) {
+		return AdaptSelector(NewRoundRobinSelector(tierServices)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return selector
+}
+
+func TestTieredSelectorPrefersPrimaries(t *testing.T) {
+	services := newTestServices(4)
+	services[2].Priority = 1
+	services[3].Priority = 1
+
+	selector := newTestTieredSelector(t, services, 1)
+	for i := 0; i < 10; i++ {
+		if service := selector.SelectService(nil); service.Priority != 0 {
+			t.Fatalf("expected a primary service to be selected, got %s", service.Name)
+		}
+	}
+}
+
+func TestTieredSelectorFailsOverAndBack(t *testing.T) {
+	services := newTestServices(4)
+	services[2].Priority = 1
+	services[3].Priority = 1
+
+	selector := newTestTieredSelector(t, services, 2)
+
+	// A single primary going down drops the tier below the threshold
+	services[0].FSM.CurrentState = StateDown
+	for i := 0; i < 10; i++ {
+		if service := selector.SelectService(nil); service.Priority != 1 {
+			t.Fatalf("expected a backup service to be selected, got %s", service.Name)
+		}
+	}
+
+	services[0].FSM.CurrentState = StateAlive
+	for i := 0; i < 10; i++ {
+		if service := selector.SelectService(nil); service.Priority != 0 {
+			t.Fatalf("expected traffic to return to the primaries, got %s", service.Name)
+		}
+	}
+}
+
+func TestTieredSelectorUsesDegradedTierAsLastResort(t *testing.T) {
+	services := newTestServices(3)
+	services[1].Priority = 1
+	services[2].Priority = 2
+	services[1].FSM.CurrentState = StateDown
+	services[2].FSM.CurrentState = StateDown
+
+	selector := newTestTieredSelector(t, services, 2)
+	if service := selector.SelectService(nil); service != services[0] {
+		t.Errorf("expected the only alive service to be selected, got %v", service)
+	}
+
+	services[0].FSM.CurrentState = StateDown
+	if service := selector.SelectService(nil); service != nil {
+		t.Errorf("expected no service to be selected, got %s", service.Name)
+	}
+}
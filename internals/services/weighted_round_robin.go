@@ -2,16 +2,21 @@ package services
 
 import "sync"
 
+// WeightedRRSelector implements the smooth weighted round-robin algorithm used by nginx, on every selection
+// each alive service gains its weight and the service with the highest current weight is selected and
+// pays back the total weight. Every cycle of sum(weights) selections gives each service exactly as many
+// connections as its weight, a share of weight_i / sum(weights), spread across the cycle instead of in a
+// single burst
 type WeightedRRSelector struct {
 	services []*Service
-	index    int
-	counter  int
+	current  []float64
 	mu       sync.Mutex
 }
 
 func NewWeightedRoundRobin(services []*Service) *WeightedRRSelector {
 	return &WeightedRRSelector{
 		services: services,
+		current:  make([]float64, len(services)),
 	}
 }
 
@@ -19,21 +24,31 @@ func (wrr *WeightedRRSelector) SelectService() *Service {
 	wrr.mu.Lock()
 	defer wrr.mu.Unlock()
 
-	if len(wrr.services) == 0 {
-		return nil
-	}
+	selected := -1
+	var total float64
 
-	for tries := 0; tries < len(wrr.services)*2; tries++ {
-		service := wrr.services[wrr.index%len(wrr.services)]
+	for i, service := range wrr.services {
+		weight := service.EffectiveWeight()
 
-		if service.FSM.CurrentState == StateAlive && wrr.counter < service.Weight {
-			wrr.counter++
-			return service
+		// Services which can't be selected don't take part in the cycle, their current weight is reset so
+		// they rejoin without a burst and don't skew the ratios of the remaining services
+		if !service.Available() || weight <= 0 {
+			wrr.current[i] = 0
+			continue
 		}
 
-		wrr.index++
-		wrr.counter = 0
+		wrr.current[i] += weight
+		total += weight
+
+		if selected == -1 || wrr.current[i] > wrr.current[selected] {
+			selected = i
+		}
+	}
+
+	if selected == -1 {
+		return nil // No healthy service were encountered
 	}
 
-	return nil
+	wrr.current[selected] -= total
+	return wrr.services[selected]
 }
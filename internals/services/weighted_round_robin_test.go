@@ -0,0 +1,125 @@
+package services
+
+import "testing"
+
+func TestWeightedRoundRobinDistribution(t *testing.T) {
+	services := newTestServices(3)
+	services[0].Weight = 5
+	services[1].Weight = 3
+	services[2].Weight = 2
+
+	selector := NewWeightedRoundRobin(services)
+
+	counts := map[*Service]int{}
+	for i := 0; i < 10000; i++ {
+		service := selector.SelectService()
+		if service == nil {
+			t.Fatal("service is nil")
+		}
+
+		counts[service]++
+	}
+
+	expected := []int{5000, 3000, 2000}
+	for i, service := range services {
+		if counts[service] != expected[i] {
+			t.Errorf("expected %d selections for %s, got %d", expected[i], service.Name, counts[service])
+		}
+	}
+}
+
+func TestWeightedRoundRobinInterleaves(t *testing.T) {
+	services := newTestServices(2)
+	services[0].Weight = 10
+	services[1].Weight = 10
+
+	selector := NewWeightedRoundRobin(services)
+
+	previous := selector.SelectService()
+	for i := 0; i < 1000; i++ {
+		service := selector.SelectService()
+		if service == previous {
+			t.Fatalf("%s was selected twice in a row at selection %d", service.Name, i)
+		}
+
+		previous = service
+	}
+}
+
+func TestWeightedRoundRobinSpreadsHeavyService(t *testing.T) {
+	services := newTestServices(3)
+	services[0].Weight = 10
+
+	selector := NewWeightedRoundRobin(services)
+
+	// Within a cycle of 12 the heavy service is picked 10 times, but never all of them in a single burst
+	longest, run := 0, 0
+	for i := 0; i < 1200; i++ {
+		if selector.SelectService() == services[0] {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+
+	if longest >= services[0].Weight {
+		t.Errorf("expected the heavy service to be interleaved, it was selected %d times in a row", longest)
+	}
+}
+
+func TestWeightedRoundRobinSkipsUnavailableServices(t *testing.T) {
+	services := newTestServices(3)
+	services[0].Weight = 3
+	services[1].Weight = 1
+	services[2].Weight = 4
+	services[2].FSM.CurrentState = StateDown
+
+	selector := NewWeightedRoundRobin(services)
+
+	counts := map[*Service]int{}
+	for i := 0; i < 4000; i++ {
+		counts[selector.SelectService()]++
+	}
+
+	if counts[services[2]] != 0 {
+		t.Errorf("a service which is down was selected %d times", counts[services[2]])
+	}
+
+	if counts[services[0]] != 3000 || counts[services[1]] != 1000 {
+		t.Errorf("expected a 3:1 split between alive services, got %d:%d", counts[services[0]], counts[services[1]])
+	}
+}
+
+func TestWeightedRoundRobinWithZeroWeight(t *testing.T) {
+	services := newTestServices(2)
+	services[0].Weight = 0
+	services[1].Weight = 1
+
+	selector := NewWeightedRoundRobin(services)
+
+	counts := map[*Service]int{}
+	for i := 0; i < 1000; i++ {
+		service := selector.SelectService()
+		if service == nil {
+			t.Fatal("service is nil")
+		}
+
+		counts[service]++
+	}
+
+	if counts[services[0]] != 500 || counts[services[1]] != 500 {
+		t.Errorf("expected a service without a weight to default to 1, got %d:%d", counts[services[0]], counts[services[1]])
+	}
+}
+
+func TestWeightedRoundRobinWithoutAliveServices(t *testing.T) {
+	services := newTestServices(2)
+	for _, service := range services {
+		service.FSM.CurrentState = StateDown
+	}
+
+	if service := NewWeightedRoundRobin(services).SelectService(); service != nil {
+		t.Errorf("expected no service to be selected, got %s", service.Name)
+	}
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"errors"
+	"math/rand/v2"
+	"sync"
+)
+
+// ZoneAwareSelector prefers services in the same zone as this splitbit instance. While the alive local
+// services provide at least threshold of the local capacity every connection stays in the zone, below it
+// a proportional share of the traffic spills over to the other zones: at half the threshold, half of the
+// connections leave the zone. Capacity is measured in effective weight so services in slow start only
+// count for the part of their weight they have ramped up to
+type ZoneAwareSelector struct {
+	local         []*Service
+	localSelector BackendSelector
+
+	remote         []*Service
+	remoteSelector BackendSelector
+
+	threshold float64
+	rng       *rand.Rand
+	mu        sync.Mutex
+}
+
+// NewZoneAwareSelector splits the services into those in zone and the rest and builds a selector for each
+// group, a seed of 0 picks a random seed for the spillover decisions
+func NewZoneAwareSelector(services []*Service, zone string, threshold float64, build SelectorBuilder, seed uint64) (*ZoneAwareSelector, error) {
+	if threshold <= 0 || threshold > 1 {
+		return nil, errors.New("zone spillover threshold must be within (0, 1]")
+	}
+
+	zs := &ZoneAwareSelector{
+		threshold: threshold,
+		rng:       newSelectorRand(seed),
+	}
+
+	for _, svc := range services {
+		if svc.Zone == zone {
+			zs.local = append(zs.local, svc)
+		} else {
+			zs.remote = append(zs.remote, svc)
+		}
+	}
+
+	var err error
+	if zs.localSelector, err = build(zs.local); err != nil {
+		return nil, err
+	}
+
+	if zs.remoteSelector, err = build(zs.remote); err != nil {
+		return nil, err
+	}
+
+	return zs, nil
+}
+
+func (zs *ZoneAwareSelector) SelectService(req *SelectionRequest) *Service {
+	first, second := zs.localSelector, zs.remoteSelector
+	if zs.spillOver() {
+		first, second = second, first
+	}
+
+	if svc := first.SelectService(req); svc != nil {
+		return svc
+	}
+
+	return second.SelectService(req)
+}
+
+// spillOver decides whether this selection should leave the local zone
+func (zs *ZoneAwareSelector) spillOver() bool {
+	health := zs.localHealth()
+	if health >= zs.threshold {
+		return false
+	}
+
+	zs.mu.Lock()
+	defer zs.mu.Unlock()
+
+	return zs.rng.Float64() >= health/zs.threshold
+}
+
+// localHealth returns the fraction of the local capacity provided by alive services
+func (zs *ZoneAwareSelector) localHealth() float64 {
+	var available, capacity float64
+	for _, svc := range zs.local {
+		capacity += svc.baseWeight()
+		if svc.Available() {
+			available += svc.EffectiveWeight()
+		}
+	}
+
+	if capacity == 0 {
+		return 0
+	}
+
+	return available / capacity
+}
@@ -0,0 +1,89 @@
+package services
+
+import "testing"
+
+func newTestZoneAwareSelector(t *testing.T, services []*Service) *ZoneAwareSelector {
+	t.Helper()
+
+	selector, err := NewZoneAwareSelector(services, "zone-a", 0.7, func(zoneServices []*Service) (BackendSelector, error) {
+		return AdaptSelector(NewRoundRobinSelector(zoneServices)), nil
+	}, 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return selector
+}
+
+func newTestZonedServices() []*Service {
+	services := newTestServices(8)
+	for i, service := range services {
+		service.Zone = "zone-a"
+		if i >= 4 {
+			service.Zone = "zone-b"
+		}
+	}
+
+	return services
+}
+
+func TestZoneAwareSelectorStaysLocal(t *testing.T) {
+	services := newTestZonedServices()
+
+	// Losing one of four local services leaves 75% of the capacity, which is above the threshold
+	services[0].FSM.CurrentState = StateDown
+
+	selector := newTestZoneAwareSelector(t, services)
+	for i := 0; i < 1000; i++ {
+		if service := selector.SelectService(nil); service.Zone != "zone-a" {
+			t.Fatalf("expected a local service to be selected, got %s in %s", service.Name, service.Zone)
+		}
+	}
+}
+
+func TestZoneAwareSelectorSpillsOverProportionally(t *testing.T) {
+	services := newTestZonedServices()
+
+	// Half of the local capacity is left, so 0.5 / 0.7 of the connections should stay in the zone
+	services[0].FSM.CurrentState = StateDown
+	services[1].FSM.CurrentState = StateDown
+
+	selector := newTestZoneAwareSelector(t, services)
+
+	local := 0
+	for i := 0; i < 7000; i++ {
+		if selector.SelectService(nil).Zone == "zone-a" {
+			local++
+		}
+	}
+
+	if local < 4700 || local > 5300 {
+		t.Errorf("expected about 5000 local selections, got %d", local)
+	}
+}
+
+func TestZoneAwareSelectorFallsBackBetweenZones(t *testing.T) {
+	services := newTestZonedServices()
+	for _, service := range services[:4] {
+		service.FSM.CurrentState = StateDown
+	}
+
+	selector := newTestZoneAwareSelector(t, services)
+	for i := 0; i < 100; i++ {
+		if service := selector.SelectService(nil); service == nil || service.Zone != "zone-b" {
+			t.Fatalf("expected a remote service while the zone is down, got %v", service)
+		}
+	}
+
+	// The only local service left is preferred over nothing when the other zone is down as well
+	services[0].FSM.CurrentState = StateAlive
+	for _, service := range services[4:] {
+		service.FSM.CurrentState = StateDown
+	}
+
+	for i := 0; i < 100; i++ {
+		if service := selector.SelectService(nil); service != services[0] {
+			t.Fatalf("expected the last local service to be selected, got %v", service)
+		}
+	}
+}
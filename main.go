@@ -10,6 +10,7 @@ import (
 	"os"
 	"os/signal"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/frostzt/splitbit/internals"
@@ -25,20 +26,74 @@ var (
 
 	// backendSelector selects one of the available services based on the algorithm
 	backendSelector services.BackendSelector
+
+	// sniffConnections enables sniffing the first bytes of every connection before selecting a backend
+	sniffConnections bool
 )
 
+// sniffTimeout is how long a connection is sniffed for, clients which don't send anything in time
+// are proxied without any sniffed metadata
+const sniffTimeout = 200 * time.Millisecond
+
+// backendBlame decides which failures of a proxied connection are the backend's fault, the first of them is
+// fed into passive health checking once the connection is done. Errors on the client side are not
+type backendBlame struct {
+	// requestSentAt is when the first byte was forwarded to the backend, 0 until then
+	requestSentAt atomic.Int64
+	responded     atomic.Bool
+	err           error
+	errOnce       sync.Once
+}
+
+// fail records a failure caused by the backend, only the first one is kept
+func (b *backendBlame) fail(err error) {
+	b.errOnce.Do(func() { b.err = err })
+}
+
+// timedOut records a read timeout on the backend side, which is only a failure if the backend was sent a
+// request and never answered it. Idle backends and clients which never sent anything are not its fault
+func (b *backendBlame) timedOut(err error) {
+	if b.requestSentAt.Load() != 0 && !b.responded.Load() {
+		b.fail(err)
+	}
+}
+
 // handleTCPConn handles incoming TCP connections
 func handleTCPConn(conn net.Conn, logger *internals.Logger) {
 	logger.Info("Accepting TCP connection from %s with destination of %s", conn.RemoteAddr().String(), conn.LocalAddr().String())
 	defer func() { _ = conn.Close() }()
 
-	backend := backendSelector.SelectService()
+	request := &services.SelectionRequest{
+		ClientAddr: conn.RemoteAddr(),
+		LocalAddr:  conn.LocalAddr(),
+	}
+
+	// Bytes read while sniffing are replayed to the backend once it is connected
+	var sniffed []byte
+	if sniffConnections {
+		metadata, data, err := services.Sniff(conn, sniffTimeout)
+		if err != nil {
+			logger.Error("failed to sniff connection from %s: %v", conn.RemoteAddr().String(), err)
+			return
+		}
+
+		sniffed = data
+		request.Metadata = metadata
+	}
+
+	backend := backendSelector.SelectService(request)
 	if backend == nil {
 		logger.Warn("No backend selected")
 		return
 	}
 
+	// Track the connection for the whole time it is being proxied, least-connections selectors rely on it
+	backend.AcquireConnection()
+	defer backend.ReleaseConnection()
+
+	dialStart := time.Now()
 	remoteConn, err := net.Dial("tcp", backend.Address())
+	backend.ReportDialResult(err)
 	if err != nil {
 		logger.Error("failed to connect to backend: %v", err)
 		return
@@ -46,6 +101,24 @@ func handleTCPConn(conn net.Conn, logger *internals.Logger) {
 
 	defer func() { _ = remoteConn.Close() }()
 
+	connectedAt := time.Now()
+	backend.ObserveConnectLatency(connectedAt.Sub(dialStart))
+
+	// The time to first byte is measured from when the request was sent or from connectedAt for protocols
+	// where the backend speaks first
+	var firstByte sync.Once
+	blame := &backendBlame{}
+
+	if len(sniffed) > 0 {
+		if _, err := remoteConn.Write(sniffed); err != nil {
+			logger.Error("failed to replay sniffed bytes to backend: %v", err)
+			backend.ReportConnectionResult(err)
+			return
+		}
+
+		blame.requestSentAt.Store(time.Now().UnixNano())
+	}
+
 	// Try and connect to the original destination
 	var streamWait sync.WaitGroup
 	streamWait.Add(2)
@@ -69,22 +142,52 @@ func handleTCPConn(conn net.Conn, logger *internals.Logger) {
 
 				var netErr net.Error
 				if errors.As(readErr, &netErr) && netErr.Timeout() {
-					logger.Warn("read timeout from %s: %v\n", src.(*net.TCPConn).RemoteAddr().String(), readErr)
+					logger.Warn("read timeout from %s: %v\n", src.(net.Conn).RemoteAddr().String(), readErr)
+
+					if src == remoteConn {
+						blame.timedOut(readErr)
+					}
 				} else {
 					logger.Error("read error %v\n", readErr)
+
+					if src == remoteConn {
+						blame.fail(readErr)
+					}
 				}
 
 				return
 			}
 
+			if src == remoteConn {
+				firstByte.Do(func() {
+					blame.responded.Store(true)
+
+					sentAt := connectedAt
+					if sent := blame.requestSentAt.Load(); sent != 0 {
+						sentAt = time.Unix(0, sent)
+					}
+
+					backend.ObserveFirstByteLatency(time.Since(sentAt))
+				})
+			}
+
 			// Set deadline before writing
 			internals.SetWriteDeadline(dst, 30*time.Second, logger)
 
 			w := io.MultiWriter(dst, monitor)
 			if _, writeError := w.Write(buf[:bytesRead]); writeError != nil {
 				logger.Error("failed to write bytes: %v", writeError)
+
+				if dst == remoteConn {
+					blame.fail(writeError)
+				}
+
 				return
 			}
+
+			if dst == remoteConn {
+				blame.requestSentAt.CompareAndSwap(0, time.Now().UnixNano())
+			}
 		}
 	}
 
@@ -100,6 +203,8 @@ func handleTCPConn(conn net.Conn, logger *internals.Logger) {
 	go streamConn(conn, remoteConn)
 
 	streamWait.Wait()
+
+	backend.ReportConnectionResult(blame.err)
 }
 
 func listenTCPConn(logger *internals.Logger) {
@@ -144,23 +249,106 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Every health and agent check is run by a single scheduler
+	scheduler := services.NewHealthCheckScheduler(config.HealthCheckConcurrency, *config.HealthCheckJitter)
+
 	// Register backend services
 	for _, service := range config.Backends {
+		checker, checkerErr := services.NewHealthChecker(service.HealthCheck)
+		if checkerErr != nil {
+			log.Fatalf("failed to create health checker for %s: %v", service.Name, checkerErr)
+		}
+
+		var passiveHealthCheck *services.PassiveHealthOptions
+		if passive := service.PassiveHealthCheck; passive != nil {
+			passiveHealthCheck = &services.PassiveHealthOptions{
+				ConsecutiveFailures: passive.ConsecutiveFailures,
+				ErrorRate:           passive.ErrorRate,
+				Window:              passive.Window,
+				MinRequests:         passive.MinRequests,
+			}
+		}
+
+		var agentCheck *services.AgentChecker
+		if agent := service.AgentCheck; agent != nil {
+			agentCheck = &services.AgentChecker{
+				Port:     agent.Port,
+				Send:     agent.Send,
+				Interval: agent.Interval,
+				Timeout:  agent.Timeout,
+			}
+		}
+
+		var flapDamping *services.FlapDampingOptions
+		if damping := service.FlapDamping; damping != nil {
+			flapDamping = &services.FlapDampingOptions{
+				HalfLife:          damping.HalfLife,
+				SuppressThreshold: damping.SuppressThreshold,
+				ReuseThreshold:    damping.ReuseThreshold,
+				MaxSuppressTime:   damping.MaxSuppressTime,
+			}
+		}
+
 		options := &services.ServiceOptions{
-			Weight:          service.Weight,
-			HealthCheckPath: service.HealthCheck,
+			Name:                service.Name,
+			HealthChecker:       checker,
+			HealthCheckInterval: service.HealthCheck.Interval,
+			HealthCheckTimeout:  service.HealthCheck.Timeout,
+			HealthCheckRise:     service.HealthCheck.Rise,
+			HealthCheckFall:     service.HealthCheck.Fall,
+			RecoveryCooldown:    service.HealthCheck.Cooldown,
+			MaxRecoveryCooldown: service.HealthCheck.MaxCooldown,
+			PassiveHealthCheck:  passiveHealthCheck,
+			AgentCheck:          agentCheck,
+			FlapDamping:         flapDamping,
+			Weight:              service.Weight,
+			Priority:            service.Priority,
+			SlowStart:           service.SlowStart,
+			Zone:                service.Zone,
 		}
 
 		svc := services.NewService(service.Host, service.Port, options, logger)
 		availableServices = append(availableServices, svc)
 
-		// Start health check loop
-		go svc.PeriodicallyHealthCheckService(ctx)
+		// Check the service right away so it doesn't sit in PENDING for a whole interval
+		scheduler.Register(svc, true)
 
 		logger.Info("Registered service: %s", service.Name)
 	}
 
-	backendSelector = services.NewRoundRobinSelector(availableServices)
+	go scheduler.Run(ctx)
+
+	// Every priority tier gets its own selector running the configured algorithm
+	buildSelector := func(tierServices []*services.Service) (services.BackendSelector, error) {
+		return services.NewSelector(config.Algorithm, tierServices, config.AlgorithmOptions)
+	}
+
+	// With a zone configured every tier prefers its backends in the same zone
+	if config.Zone != "" {
+		buildAlgorithm := buildSelector
+		buildSelector = func(tierServices []*services.Service) (services.BackendSelector, error) {
+			return services.NewZoneAwareSelector(tierServices, config.Zone, *config.ZoneSpilloverThreshold, buildAlgorithm, 0)
+		}
+	}
+
+	backendSelector, err = services.NewTieredSelector(availableServices, config.MinHealthy, buildSelector)
+	if err != nil {
+		log.Fatalf("failed to create backend selector: %v", err)
+	}
+
+	if config.StickTable != nil {
+		key, keyErr := services.ParseHashKey(config.StickTable.Key)
+		if keyErr != nil {
+			log.Fatalf("failed to create stick table: %v", keyErr)
+		}
+
+		backendSelector, err = services.NewStickTable(backendSelector, key, config.StickTable.TTL, config.StickTable.MaxSize)
+		if err != nil {
+			log.Fatalf("failed to create stick table: %v", err)
+		}
+	}
+
+	sniffConnections = config.Sniff
 
 	tcpListener, err = internals.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("0.0.0.0"), Port: config.Port})
 	if err != nil {
@@ -173,7 +361,7 @@ func main() {
 	go listenTCPConn(logger)
 
 	// Listen for interrupts
-	interruptListener := make(chan os.Signal)
+	interruptListener := make(chan os.Signal, 1)
 	signal.Notify(interruptListener, os.Interrupt)
 	<-interruptListener
 
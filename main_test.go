@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBackendBlameOnReadTimeout(t *testing.T) {
+	timeout := os.ErrDeadlineExceeded
+
+	// A client which connected and never sent anything leaves the backend with nothing to answer
+	idle := &backendBlame{}
+	idle.timedOut(timeout)
+	if idle.err != nil {
+		t.Errorf("expected an idle client not to blame the backend, got %v", idle.err)
+	}
+
+	unanswered := &backendBlame{}
+	unanswered.requestSentAt.Store(time.Now().UnixNano())
+	unanswered.timedOut(timeout)
+	if unanswered.err == nil {
+		t.Error("expected a backend which never answered a request to be blamed")
+	}
+
+	answered := &backendBlame{}
+	answered.requestSentAt.Store(time.Now().UnixNano())
+	answered.responded.Store(true)
+	answered.timedOut(timeout)
+	if answered.err != nil {
+		t.Errorf("expected an idle backend which already answered not to be blamed, got %v", answered.err)
+	}
+}